@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// appendCheckSize is how much of the destination's tail is compared with
+// the source to confirm the destination is a prefix before appending.
+const appendCheckSize = 64 * 1024
+
+// appendOffset reports where to resume copying src into an existing dst
+// for --append: the destination must be a regular file shorter than the
+// source whose last bytes match the source at the same position. An empty
+// destination gives offset 0: the whole source is to be copied over it.
+func appendOffset(src, dst string, srcInfo, dstInfo fs.FileInfo) (int64, bool) {
+	if !dstInfo.Mode().IsRegular() || dstInfo.Size() >= srcInfo.Size() {
+		return 0, false
+	}
+	offset := dstInfo.Size()
+	n := int64(appendCheckSize)
+	if offset < n {
+		n = offset
+	}
+	if n == 0 {
+		return 0, true
+	}
+	a, errA := readAt(src, offset-n, n)
+	b, errB := readAt(dst, offset-n, n)
+	if errA != nil || errB != nil || !bytes.Equal(a, b) {
+		return 0, false
+	}
+	return offset, true
+}
+
+func readAt(path string, off, n int64) ([]byte, error) {
+	f, err := openFiles.open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, n)
+	_, err = f.ReadAt(buf, off)
+	return buf, err
+}
+
+// appendFile copies the part of op.src beyond op.offset onto the end of
+// op.dst.
+func appendFile(ctx context.Context, op transferOp) error {
+	info, err := os.Stat(op.src)
+	if err != nil {
+		return err
+	}
+
+	status.setCurrentFile(op.rel)
+	if screen != nil {
+		screen.setFile(op.rel, info.Size()-op.offset)
+	}
+
+	in, err := openFiles.open(op.src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if _, err := in.Seek(op.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	out, err := openFiles.openFile(op.dst, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if st, err := out.Stat(); err != nil || st.Size() != op.offset {
+		out.Close()
+		return fmt.Errorf("%s changed since it was scanned", op.dst)
+	}
+	if undoLog != nil {
+		if err := undoLog.record(undoEntry{Op: "append", Path: op.dst, Size: op.offset}); err != nil {
+			out.Close()
+			return err
+		}
+	}
+
+	progress := &progressWriter{fileName: filepath.Base(op.src), total: info.Size() - op.offset, ctx: ctx}
+	_, err = copyData(throttle(out), io.TeeReader(in, progress))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = applyChmod(op.dst, info.Mode())
+	}
+	if err == nil {
+		err = preserveTimes(op.dst, info)
+	}
+	if err == nil && verifyFlag {
+		// Only the tail was read, so the whole source is hashed here
+		var want string
+		if want, err = hashFile(op.src); err == nil {
+			err = verifyCopy(op.dst, want)
+		}
+	}
+	if err != nil {
+		// Put the destination back the way it was
+		os.Truncate(op.dst, op.offset)
+		atomic.AddInt64(&overallProgress, -atomic.LoadInt64(&progress.current))
+		return err
+	}
+	endProgressLine()
+	return nil
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A backup directory holds one directory per incremental set with the
+// files that set added or changed under data/ (or, with --dedup, in the
+// shared chunks/ store), and a manifest of the whole source tree as it was
+// then. catalog.jsonl lists the sets, oldest first;
+// a set only counts once its catalog line is written.
+const (
+	backupCatalog  = "catalog.jsonl"
+	backupManifest = "manifest.json"
+	backupData     = "data"
+)
+
+// backupSet is one line of the catalog: when a set was made and what it
+// changed.
+type backupSet struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Added   int       `json:"added"`
+	Changed int       `json:"changed"`
+	Deleted int       `json:"deleted"`
+	Bytes   int64     `json:"bytes"`
+	Stored  int64     `json:"stored,omitempty"`
+}
+
+// backupTree is the full tree at the time of a set. Each file names
+// the set holding its content, which is an older one if it didn't change.
+type backupTree struct {
+	ID      string        `json:"id"`
+	Created time.Time     `json:"created"`
+	Dirs    []string      `json:"dirs"`
+	Files   []backupEntry `json:"files"`
+	Deleted []string      `json:"deleted,omitempty"`
+}
+
+type backupEntry struct {
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	MTimeNs int64       `json:"mtime"`
+	Mode    fs.FileMode `json:"mode"`
+	Set     string      `json:"set"`
+	Chunked bool        `json:"chunked,omitempty"`
+	Chunks  []string    `json:"chunks,omitempty"`
+}
+
+// runBackup stores the files of source that changed since the last set
+// as a new dated set in the backup directory.
+func runBackup(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only list what the new set would hold")
+	dedup := fs.Bool("dedup", false, "store contents as deduplicated content-defined chunks shared by all sets")
+	compress := fs.Bool("compress", false, "compress the chunks stored by --dedup")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s backup [--dry-run] [--dedup [--compress]] <source> <backup-dir>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseSubcommand(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		exit(1)
+	}
+	if *compress && !*dedup {
+		fmt.Fprintf(os.Stderr, "Error: --compress requires --dedup\n")
+		exit(1)
+	}
+	srcRoot := filepath.Clean(fs.Arg(0))
+	backupRoot := filepath.Clean(fs.Arg(1))
+	if _, err := os.Stat(srcRoot); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := os.MkdirAll(backupRoot, 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := checkOverlap(srcRoot, backupRoot); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+
+	set, err := backupTreeTo(srcRoot, backupRoot, !*dryRun, *dedup, *compress)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	switch {
+	case set.Added+set.Changed+set.Deleted == 0:
+		fmt.Println("No changes since the last backup set.")
+	case *dryRun:
+		logSummary("Preview: %d added, %d changed, %d deleted (%s)\n", set.Added, set.Changed, set.Deleted, formatSize(set.Bytes))
+	case *dedup:
+		logSummary("Backup set %s: %d added, %d changed, %d deleted (%s, %s new in the chunk store)\n", set.ID, set.Added, set.Changed, set.Deleted, formatSize(set.Bytes), formatSize(set.Stored))
+	default:
+		logSummary("Backup set %s: %d added, %d changed, %d deleted (%s)\n", set.ID, set.Added, set.Changed, set.Deleted, formatSize(set.Bytes))
+	}
+	if failed > 0 {
+		logSummary("Backup finished with errors: %d files could not be stored\n", failed)
+		exit(1)
+	}
+}
+
+// backupTreeTo compares srcRoot with the newest set in backupRoot and,
+// when apply is set, writes the differences as a new set, into the chunk
+// store if dedup is set.
+func backupTreeTo(srcRoot, backupRoot string, apply, dedup, compress bool) (backupSet, error) {
+	now := time.Now().UTC()
+	set := backupSet{ID: now.Format("20060102T150405Z"), Created: now}
+	tree := backupTree{ID: set.ID, Created: now}
+
+	previous := map[string]backupEntry{}
+	sets, err := readBackupCatalog(backupRoot)
+	if err != nil {
+		return set, err
+	}
+	if len(sets) > 0 {
+		last, err := readBackupTree(backupRoot, sets[len(sets)-1].ID)
+		if err != nil {
+			return set, err
+		}
+		for _, e := range last.Files {
+			previous[e.Path] = e
+		}
+	}
+
+	setDir := filepath.Join(backupRoot, set.ID)
+	if apply && exists(setDir) {
+		return set, fmt.Errorf("backup set %s already exists; try again in a second", set.ID)
+	}
+	filters.useRoot(srcRoot)
+	err = filepath.WalkDir(srcRoot, func(path string, d fs.DirEntry, err error) error {
+		rel, _ := filepath.Rel(srcRoot, path)
+		if err != nil {
+			if d == nil || handleFailure(rel, err) != nil {
+				return err
+			}
+			return skipEntry(d)
+		}
+		if rel == "." {
+			return nil
+		}
+		if d.Name() == undoDirName || excluded(rel, d.IsDir()) || d.IsDir() && skipMount(path, rel) {
+			return skipEntry(d)
+		}
+		if d.IsDir() {
+			tree.Dirs = append(tree.Dirs, filepath.ToSlash(rel))
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		prev, seen := previous[filepath.ToSlash(rel)]
+		delete(previous, filepath.ToSlash(rel))
+		// A file that can't be stored keeps its previous version in the
+		// set, so restoring as of it doesn't lose the file
+		keepPrevious := func(err error) error {
+			if seen {
+				tree.Files = append(tree.Files, prev)
+			}
+			return handleFailure(rel, err)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return keepPrevious(err)
+		}
+
+		entry := backupEntry{Path: filepath.ToSlash(rel), Size: info.Size(), MTimeNs: info.ModTime().UnixNano(), Mode: info.Mode(), Set: set.ID}
+		if seen && prev.Size == entry.Size && prev.MTimeNs == entry.MTimeNs {
+			entry.Set, entry.Chunked, entry.Chunks = prev.Set, prev.Chunked, prev.Chunks
+			tree.Files = append(tree.Files, entry)
+			return nil
+		}
+
+		if apply && dedup {
+			chunks, stored, err := storeChunks(backupRoot, path, compress)
+			if err != nil {
+				return keepPrevious(err)
+			}
+			entry.Chunked, entry.Chunks = true, chunks
+			set.Stored += stored
+		} else if apply {
+			if _, err := streamFile(path, filepath.Join(setDir, backupData, rel), info.Mode(), false, io.Discard); err != nil {
+				return keepPrevious(err)
+			}
+		}
+		if seen {
+			set.Changed++
+			logOp(os.Stdout, "[CHANGE] %s\n", rel)
+		} else {
+			set.Added++
+			logOp(os.Stdout, "[ADD] %s\n", rel)
+		}
+		set.Bytes += entry.Size
+		tree.Files = append(tree.Files, entry)
+		return nil
+	})
+	if err != nil {
+		return set, err
+	}
+
+	for path := range previous {
+		tree.Deleted = append(tree.Deleted, path)
+	}
+	sort.Strings(tree.Deleted)
+	for _, path := range tree.Deleted {
+		logOp(os.Stdout, "[DELETE] %s\n", filepath.FromSlash(path))
+	}
+	set.Deleted = len(tree.Deleted)
+
+	if !apply || set.Added+set.Changed+set.Deleted == 0 {
+		return set, nil
+	}
+	if err := writeBackupTree(backupRoot, tree); err != nil {
+		return set, err
+	}
+	return set, appendBackupCatalog(backupRoot, set)
+}
+
+// runRestore recreates the tree as it was at the newest set made no later
+// than --as-of.
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	asOf := fs.String("as-of", "", "restore the tree as it was at this time (2006-01-02, 2006-01-02 15:04 or RFC 3339; default: latest)")
+	list := fs.Bool("list", false, "list the backup sets instead of restoring")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s restore [--as-of DATE] <backup-dir> <target>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "   or: %s restore --list <backup-dir>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseSubcommand(fs, args)
+	if *list && fs.NArg() == 1 {
+		listBackupSets(filepath.Clean(fs.Arg(0)))
+		return
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		exit(1)
+	}
+	backupRoot := filepath.Clean(fs.Arg(0))
+	dstRoot := filepath.Clean(fs.Arg(1))
+
+	when := time.Now()
+	if *asOf != "" {
+		var err error
+		if when, err = parseAsOf(*asOf); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+	}
+	sets, err := readBackupCatalog(backupRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	var chosen *backupSet
+	for i := range sets {
+		if !sets[i].Created.After(when) {
+			chosen = &sets[i]
+		}
+	}
+	if chosen == nil {
+		fmt.Fprintf(os.Stderr, "Error: no backup set in %s from %s or earlier\n", backupRoot, when.Format(time.DateTime))
+		exit(1)
+	}
+	tree, err := readBackupTree(backupRoot, chosen.ID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Restoring set %s (%s)\n", tree.ID, tree.Created.Local().Format(time.DateTime))
+	if err := os.MkdirAll(dstRoot, 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	var restored, skipped int
+	for _, dir := range tree.Dirs {
+		if err := os.MkdirAll(filepath.Join(dstRoot, filepath.FromSlash(dir)), 0o755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+	}
+	for _, e := range tree.Files {
+		rel := filepath.FromSlash(e.Path)
+		dst := filepath.Join(dstRoot, rel)
+		if _, err := os.Lstat(dst); err == nil {
+			logOp(os.Stdout, "[SKIP] %s\n", rel)
+			skipped++
+			continue
+		}
+		logOp(os.Stdout, "[RESTORE] %s\n", rel)
+		var err error
+		if e.Chunked {
+			err = restoreChunks(backupRoot, e, dst)
+		} else {
+			_, err = streamFile(filepath.Join(backupRoot, e.Set, backupData, rel), dst, e.Mode, false, io.Discard)
+		}
+		if err != nil {
+			if err := handleFailure(rel, err); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				exit(1)
+			}
+			continue
+		}
+		restored++
+	}
+	logSummary("Restore complete: %d files restored, %d skipped\n", restored, skipped)
+	if failed > 0 {
+		exit(1)
+	}
+}
+
+// listBackupSets prints the catalog of backupRoot.
+func listBackupSets(backupRoot string) {
+	sets, err := readBackupCatalog(backupRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	for _, s := range sets {
+		fmt.Printf("%s  %s  %d added, %d changed, %d deleted (%s)\n",
+			s.ID, s.Created.Local().Format(time.DateTime), s.Added, s.Changed, s.Deleted, formatSize(s.Bytes))
+	}
+}
+
+// parseAsOf reads a --as-of time. A bare date means the end of that day.
+func parseAsOf(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{time.DateTime, "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --as-of %q", s)
+}
+
+func readBackupCatalog(backupRoot string) ([]backupSet, error) {
+	f, err := os.Open(filepath.Join(backupRoot, backupCatalog))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var sets []backupSet
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var s backupSet
+		if err := json.Unmarshal([]byte(line), &s); err != nil {
+			return nil, fmt.Errorf("%s: %w", backupCatalog, err)
+		}
+		sets = append(sets, s)
+	}
+	return sets, sc.Err()
+}
+
+func appendBackupCatalog(backupRoot string, set backupSet) error {
+	data, err := json.Marshal(set)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(backupRoot, backupCatalog), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readBackupTree(backupRoot, id string) (backupTree, error) {
+	var tree backupTree
+	data, err := os.ReadFile(filepath.Join(backupRoot, id, backupManifest))
+	if err != nil {
+		return tree, err
+	}
+	err = json.Unmarshal(data, &tree)
+	return tree, err
+}
+
+func writeBackupTree(backupRoot string, tree backupTree) error {
+	dir := filepath.Join(backupRoot, tree.ID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, backupManifest+partSuffix)
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, backupManifest))
+}
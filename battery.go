@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// pauseOnBatteryFlag holds transfers while a laptop runs on battery and
+// lets them continue once it is plugged in again.
+var pauseOnBatteryFlag bool
+
+const (
+	powerPollInterval = 30 * time.Second
+	holdBattery       = "on battery"
+)
+
+// startBatteryMonitor checks the power source now and then keeps watching
+// it for as long as the run lasts.
+func startBatteryMonitor() error {
+	battery, err := onBattery()
+	if err != nil {
+		return fmt.Errorf("--pause-on-battery: %w", err)
+	}
+	if battery {
+		control.hold(holdBattery, true)
+		logOp(os.Stderr, "[POWER] On battery, waiting for AC power\n")
+	}
+	go func() {
+		for range time.Tick(powerPollInterval) {
+			now, err := onBattery()
+			if err != nil || now == battery {
+				continue
+			}
+			battery = now
+			control.hold(holdBattery, battery)
+			if battery {
+				logOp(os.Stderr, "[POWER] On battery, waiting for AC power\n")
+			} else {
+				logOp(os.Stderr, "[POWER] On AC power, resuming\n")
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// onBattery asks pmset which power source is in use.
+func onBattery() (bool, error) {
+	out, err := exec.Command("pmset", "-g", "batt").Output()
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(string(out), "'Battery Power'"), nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const powerSupplyDir = "/sys/class/power_supply"
+
+// onBattery reports whether the machine has a battery and no mains or USB
+// power supply online.
+func onBattery() (bool, error) {
+	entries, err := os.ReadDir(powerSupplyDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	hasBattery := false
+	for _, e := range entries {
+		dir := filepath.Join(powerSupplyDir, e.Name())
+		switch readSysfs(filepath.Join(dir, "type")) {
+		case "Battery":
+			// Peripheral batteries (mice, headsets) say scope=Device
+			if readSysfs(filepath.Join(dir, "scope")) != "Device" {
+				hasBattery = true
+			}
+		case "Mains", "USB", "USB_C", "USB_PD":
+			if readSysfs(filepath.Join(dir, "online")) == "1" {
+				return false, nil
+			}
+		}
+	}
+	return hasBattery, nil
+}
+
+func readSysfs(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetSystemPowerStatus = syscall.NewLazyDLL("kernel32.dll").NewProc("GetSystemPowerStatus")
+
+// onBattery reports whether Windows says the AC line is offline.
+func onBattery() (bool, error) {
+	var status struct {
+		ACLineStatus        byte
+		BatteryFlag         byte
+		BatteryLifePercent  byte
+		SystemStatusFlag    byte
+		BatteryLifeTime     uint32
+		BatteryFullLifeTime uint32
+	}
+	if r, _, err := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&status))); r == 0 {
+		return false, err
+	}
+	return status.ACLineStatus == 0, nil
+}
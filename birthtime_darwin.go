@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// setBirthTime lowers the creation time of path to btime. macOS moves the
+// birth time back whenever the modification time is set earlier than it,
+// so setting the mtime to btime first does it; the real mtime follows.
+func setBirthTime(path string, btime time.Time) error {
+	return os.Chtimes(path, time.Time{}, btime)
+}
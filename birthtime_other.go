@@ -0,0 +1,10 @@
+//go:build !darwin && !windows
+
+package main
+
+import "time"
+
+// setBirthTime does nothing where creation times can't be set.
+func setBirthTime(path string, btime time.Time) error {
+	return nil
+}
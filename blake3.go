@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// blake3 is a streaming, portable BLAKE3-256 for checksums that should
+// be both fast and cryptographically strong.
+type blake3 struct {
+	chunk   blake3Chunk
+	stack   [][8]uint32 // chaining values of completed subtrees
+	counter uint64      // chunks completed so far
+}
+
+const (
+	b3ChunkLen   = 1024
+	b3BlockLen   = 64
+	b3ChunkStart = 1 << 0
+	b3ChunkEnd   = 1 << 1
+	b3Parent     = 1 << 2
+	b3Root       = 1 << 3
+)
+
+var b3IV = [8]uint32{0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A, 0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19}
+
+var b3Permutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+func newBLAKE3() hash.Hash {
+	h := &blake3{}
+	h.Reset()
+	return h
+}
+
+func (h *blake3) Reset() {
+	h.chunk = blake3Chunk{cv: b3IV}
+	h.stack = h.stack[:0]
+	h.counter = 0
+}
+
+func (h *blake3) Size() int      { return 32 }
+func (h *blake3) BlockSize() int { return b3BlockLen }
+
+func (h *blake3) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if h.chunk.length() == b3ChunkLen {
+			// Only finish a chunk once more input shows it isn't the root
+			cv := h.chunk.output().chainingValue()
+			h.counter++
+			for total := h.counter; total&1 == 0; total >>= 1 {
+				left := h.stack[len(h.stack)-1]
+				h.stack = h.stack[:len(h.stack)-1]
+				cv = b3ParentOutput(left, cv).chainingValue()
+			}
+			h.stack = append(h.stack, cv)
+			h.chunk = blake3Chunk{cv: b3IV, counter: h.counter}
+		}
+		take := min(b3ChunkLen-h.chunk.length(), len(p))
+		h.chunk.write(p[:take])
+		p = p[take:]
+	}
+	return n, nil
+}
+
+func (h *blake3) Sum(b []byte) []byte {
+	out := h.chunk.output()
+	for i := len(h.stack) - 1; i >= 0; i-- {
+		out = b3ParentOutput(h.stack[i], out.chainingValue())
+	}
+	words := b3Compress(out.cv, out.block, 0, out.blockLen, out.flags|b3Root)
+	for _, w := range words[:8] {
+		b = binary.LittleEndian.AppendUint32(b, w)
+	}
+	return b
+}
+
+// blake3Chunk is the state of the chunk currently being hashed.
+type blake3Chunk struct {
+	cv       [8]uint32
+	counter  uint64
+	block    [b3BlockLen]byte
+	blockLen int
+	blocks   int // blocks compressed so far
+}
+
+func (c *blake3Chunk) length() int { return c.blocks*b3BlockLen + c.blockLen }
+
+func (c *blake3Chunk) startFlag() uint32 {
+	if c.blocks == 0 {
+		return b3ChunkStart
+	}
+	return 0
+}
+
+func (c *blake3Chunk) write(p []byte) {
+	for len(p) > 0 {
+		if c.blockLen == b3BlockLen {
+			words := b3Compress(c.cv, b3Words(&c.block), c.counter, b3BlockLen, c.startFlag())
+			copy(c.cv[:], words[:8])
+			c.blocks++
+			c.block = [b3BlockLen]byte{}
+			c.blockLen = 0
+		}
+		n := copy(c.block[c.blockLen:], p)
+		c.blockLen += n
+		p = p[n:]
+	}
+}
+
+func (c *blake3Chunk) output() b3Output {
+	return b3Output{cv: c.cv, block: b3Words(&c.block), counter: c.counter, blockLen: uint32(c.blockLen), flags: c.startFlag() | b3ChunkEnd}
+}
+
+// b3Output is a compression that hasn't been run yet, because it may turn
+// out to be the root and need the root flag.
+type b3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o b3Output) chainingValue() [8]uint32 {
+	words := b3Compress(o.cv, o.block, o.counter, o.blockLen, o.flags)
+	return [8]uint32(words[:8])
+}
+
+func b3ParentOutput(left, right [8]uint32) b3Output {
+	var block [16]uint32
+	copy(block[:8], left[:])
+	copy(block[8:], right[:])
+	return b3Output{cv: b3IV, block: block, blockLen: b3BlockLen, flags: b3Parent}
+}
+
+func b3Words(b *[b3BlockLen]byte) [16]uint32 {
+	var w [16]uint32
+	for i := range w {
+		w[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return w
+}
+
+func b3Compress(cv [8]uint32, m [16]uint32, counter uint64, blockLen, flags uint32) [16]uint32 {
+	s := [16]uint32{
+		cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7],
+		b3IV[0], b3IV[1], b3IV[2], b3IV[3],
+		uint32(counter), uint32(counter >> 32), blockLen, flags,
+	}
+	for round := 0; round < 7; round++ {
+		b3G(&s, 0, 4, 8, 12, m[0], m[1])
+		b3G(&s, 1, 5, 9, 13, m[2], m[3])
+		b3G(&s, 2, 6, 10, 14, m[4], m[5])
+		b3G(&s, 3, 7, 11, 15, m[6], m[7])
+		b3G(&s, 0, 5, 10, 15, m[8], m[9])
+		b3G(&s, 1, 6, 11, 12, m[10], m[11])
+		b3G(&s, 2, 7, 8, 13, m[12], m[13])
+		b3G(&s, 3, 4, 9, 14, m[14], m[15])
+		var permuted [16]uint32
+		for i, j := range b3Permutation {
+			permuted[i] = m[j]
+		}
+		m = permuted
+	}
+	for i := 0; i < 8; i++ {
+		s[i] ^= s[i+8]
+		s[i+8] ^= cv[i]
+	}
+	return s
+}
+
+func b3G(s *[16]uint32, a, b, c, d int, mx, my uint32) {
+	s[a] += s[b] + mx
+	s[d] = bits.RotateLeft32(s[d]^s[a], -16)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -12)
+	s[a] += s[b] + my
+	s[d] = bits.RotateLeft32(s[d]^s[a], -8)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -7)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+const defaultBufferSize = 1 << 20
+
+// copyBufferSize is the size of each pooled copy buffer (--buffer-size).
+var copyBufferSize = defaultBufferSize
+
+// copyBuffers recycles copy buffers between files and workers so each
+// copy doesn't allocate (and later collect) a buffer of its own.
+var copyBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, copyBufferSize)
+		return &b
+	},
+}
+
+func setBufferSize(s string) error {
+	n, err := parseSize(s)
+	if err != nil {
+		return err
+	}
+	if n < 4<<10 || n > 1<<30 {
+		return fmt.Errorf("--buffer-size must be between 4K and 1G, got %s", s)
+	}
+	copyBufferSize = int(n)
+	return nil
+}
+
+// copyData is io.Copy through a pooled buffer. dst is wrapped so the
+// ReadFrom of *os.File can't bypass the buffer with a fresh one.
+func copyData(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	return io.CopyBuffer(struct{ io.Writer }{dst}, src, *buf)
+}
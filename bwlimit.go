@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bwSlot is one entry of a --bwlimit timetable: from start (minutes after
+// midnight) until the next entry, transfers are held to rate bytes per
+// second, or unlimited when rate is 0.
+type bwSlot struct {
+	start int
+	rate  int64
+}
+
+// bandwidth throttles the data written by transfers for --bwlimit.
+var bandwidth = &bwLimiter{}
+
+// bwLimiter spaces writes to follow the rate of the current time window,
+// which is looked up on every write so a schedule applies live.
+type bwLimiter struct {
+	mu       sync.Mutex
+	schedule []bwSlot
+	next     time.Time
+}
+
+// parseBwLimit parses --bwlimit: either a single rate ("5M", "off") or a
+// timetable of "HH:MM,RATE" entries separated by spaces, for example
+// "01:00,off 06:00,5M". A timetable wraps around midnight.
+func parseBwLimit(s string) ([]bwSlot, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 1 && !strings.Contains(fields[0], ",") {
+		rate, err := parseRate(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		return []bwSlot{{0, rate}}, nil
+	}
+	var schedule []bwSlot
+	for _, f := range fields {
+		at, rateStr, ok := strings.Cut(f, ",")
+		if !ok {
+			return nil, fmt.Errorf("invalid --bwlimit entry %q (want HH:MM,RATE)", f)
+		}
+		t, err := time.Parse("15:04", at)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --bwlimit time %q", at)
+		}
+		rate, err := parseRate(rateStr)
+		if err != nil {
+			return nil, err
+		}
+		schedule = append(schedule, bwSlot{t.Hour()*60 + t.Minute(), rate})
+	}
+	slices.SortFunc(schedule, func(a, b bwSlot) int { return a.start - b.start })
+	for i := 1; i < len(schedule); i++ {
+		if schedule[i].start == schedule[i-1].start {
+			return nil, fmt.Errorf("--bwlimit lists %02d:%02d twice", schedule[i].start/60, schedule[i].start%60)
+		}
+	}
+	return schedule, nil
+}
+
+// parseRate parses a bytes-per-second rate; "off" and 0 mean unlimited.
+func parseRate(s string) (int64, error) {
+	if strings.EqualFold(s, "off") {
+		return 0, nil
+	}
+	rate, err := parseSize(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --bwlimit rate %q", s)
+	}
+	return rate, nil
+}
+
+// rateAt returns the limit in force at t.
+func rateAt(schedule []bwSlot, t time.Time) int64 {
+	if len(schedule) == 0 {
+		return 0
+	}
+	minute := t.Hour()*60 + t.Minute()
+	// Before the first entry of the day the last one is still in force
+	rate := schedule[len(schedule)-1].rate
+	for _, slot := range schedule {
+		if slot.start > minute {
+			break
+		}
+		rate = slot.rate
+	}
+	return rate
+}
+
+// wait blocks until n more bytes may be written under the current rate.
+func (l *bwLimiter) wait(n int) {
+	if len(l.schedule) == 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	rate := rateAt(l.schedule, now)
+	if rate == 0 {
+		l.next = now
+		l.mu.Unlock()
+		return
+	}
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / rate))
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// throttle wraps w so writes follow --bwlimit.
+func throttle(w io.Writer) io.Writer {
+	if len(bandwidth.schedule) == 0 {
+		return w
+	}
+	return throttledWriter{w}
+}
+
+type throttledWriter struct {
+	w io.Writer
+}
+
+// Write passes p on in pieces of at most a quarter second's worth, so
+// slow limits don't stall progress for a whole buffer at a time.
+func (t throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		piece := len(p)
+		if rate := rateAt(bandwidth.schedule, time.Now()); rate > 0 && int64(piece) > rate/4+1 {
+			piece = int(rate/4 + 1)
+		}
+		bandwidth.wait(piece)
+		n, err := t.w.Write(p[:piece])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[piece:]
+	}
+	return written, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// caseSuffix marks the intermediate name of a two-step case-only rename.
+const caseSuffix = ".lyphotos-case"
+
+// The names in the target directory fixCase looked at last; the walk only
+// looks at one directory's entries at a time. The transfers add what they
+// create there, hence the lock.
+var (
+	caseMu    sync.Mutex
+	caseDir   string
+	caseNames map[string]bool
+)
+
+// fixCase handles an entry that exists on the target only under a name
+// differing in case, as happens on a case-insensitive target after the
+// source was renamed. It renames the target entry to the source's name
+// in two steps, since a direct rename would be a no-op there.
+func fixCase(dstPath, rel string) error {
+	dir, name := filepath.Split(dstPath)
+	if swapCase(name) == name {
+		return nil
+	}
+	found, err := caseVariant(dir, name)
+	if err != nil || found == "" {
+		return err
+	}
+	// Only a case-insensitive filesystem finds both spellings
+	exact, err := os.Lstat(dstPath)
+	if err != nil {
+		return nil
+	}
+	other, err := os.Lstat(filepath.Join(dir, found))
+	if err != nil || !os.SameFile(exact, other) {
+		return nil
+	}
+
+	oldRel := filepath.Join(filepath.Dir(rel), found)
+	if !applyFlag {
+		logOp(os.Stdout, "[RENAME] %s -> %s\n", oldRel, rel)
+		return nil
+	}
+	logOp(os.Stderr, "[RENAME] %s -> %s\n", oldRel, rel)
+	old := filepath.Join(dir, found)
+	fileOps.wait()
+	if err := renameFile(old, old+caseSuffix); err != nil {
+		return err
+	}
+	if err := renameFile(old+caseSuffix, dstPath); err != nil {
+		return err
+	}
+	caseMu.Lock()
+	if dir == caseDir {
+		delete(caseNames, found)
+		delete(caseNames, found+caseSuffix)
+	}
+	caseMu.Unlock()
+	return nil
+}
+
+// caseVariant returns the name the target directory dir holds name under
+// when that differs only in case, or "" if it holds name exactly or not
+// at all. dir is listed once, however many of its entries are looked up.
+func caseVariant(dir, name string) (string, error) {
+	caseMu.Lock()
+	defer caseMu.Unlock()
+	if dir != caseDir {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return "", err
+		}
+		caseDir, caseNames = dir, make(map[string]bool, len(entries))
+		for _, e := range entries {
+			caseNames[e.Name()] = true
+		}
+	}
+	if caseNames[name] {
+		return "", nil
+	}
+	for n := range caseNames {
+		if strings.EqualFold(n, name) {
+			return n, nil
+		}
+	}
+	return "", nil
+}
+
+// noteTargetEntry adds a target entry the run created or renamed into
+// place to the names fixCase knows, if it lists that directory.
+func noteTargetEntry(path string) {
+	dir, name := filepath.Split(path)
+	caseMu.Lock()
+	if dir == caseDir {
+		caseNames[name] = true
+	}
+	caseMu.Unlock()
+}
+
+// swapCase turns upper case letters into lower case and the other way
+// round.
+func swapCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsUpper(r) {
+			return unicode.ToLower(r)
+		}
+		return unicode.ToUpper(r)
+	}, s)
+}
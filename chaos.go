@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"sync"
+)
+
+// faultHook, when set, is consulted before every open, read and write a
+// transfer performs; a non-nil result is returned in place of the real
+// operation. --chaos installs a random injector, and tests can install
+// their own to fail specific paths deterministically.
+var faultHook func(op, path string) error
+
+// hiddenFlags are registered like any other flag but left out of -h.
+var hiddenFlags = map[string]bool{"chaos": true, "chaos-seed": true}
+
+func injectFault(op, path string) error {
+	if faultHook == nil {
+		return nil
+	}
+	return faultHook(op, path)
+}
+
+// enableChaos injects an error into roughly rate of all I/O operations and
+// occasionally kills the process mid-write to simulate a crash, leaving
+// whatever partial state exists on disk.
+func enableChaos(rate float64, seed uint64) {
+	var mu sync.Mutex
+	rng := rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))
+	faultHook = func(op, path string) error {
+		mu.Lock()
+		roll := rng.Float64()
+		mu.Unlock()
+		if op == "write" && roll < rate/10 {
+			fmt.Fprintf(os.Stderr, "chaos: simulated crash while writing %s\n", path)
+			os.Exit(3)
+		}
+		if roll < rate {
+			return fmt.Errorf("chaos: injected %s error on %s", op, path)
+		}
+		return nil
+	}
+}
+
+type faultReader struct {
+	r    io.Reader
+	path string
+}
+
+func (f faultReader) Read(p []byte) (int, error) {
+	if err := injectFault("read", f.path); err != nil {
+		return 0, err
+	}
+	return f.r.Read(p)
+}
+
+type faultWriter struct {
+	w    io.Writer
+	path string
+}
+
+func (f faultWriter) Write(p []byte) (int, error) {
+	if err := injectFault("write", f.path); err != nil {
+		return 0, err
+	}
+	return f.w.Write(p)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Runs in discovery order save a checkpoint: the last file transferred,
+// written every --checkpoint-files files or --checkpoint-interval,
+// whichever comes first. After a crash, --resume picks up from it without
+// walking and comparing everything before it again. A run that completes
+// removes its checkpoint.
+var (
+	checkpointFiles    int
+	checkpointInterval time.Duration
+	resumeFlag         bool
+)
+
+// checkpointState is the saved checkpoint. Last uses forward slashes.
+type checkpointState struct {
+	Source string    `json:"source"`
+	Target string    `json:"target"`
+	Last   string    `json:"last"`
+	Files  int64     `json:"files"`
+	Time   time.Time `json:"time"`
+}
+
+type checkpointer struct {
+	mu      sync.Mutex
+	path    string
+	state   checkpointState
+	pending int
+	written time.Time
+}
+
+// checkpoints is nil unless this run saves checkpoints.
+var checkpoints *checkpointer
+
+// resumeAfter is the checkpoint --resume continues after.
+var resumeAfter string
+
+func checkpointPath(srcRoot, dstRoot string) (path, src, dst string, err error) {
+	src, dst, id, err := pairID(srcRoot, dstRoot)
+	if err != nil {
+		return "", "", "", err
+	}
+	path, err = userCacheFile("checkpoint-" + id + ".json")
+	return path, src, dst, err
+}
+
+// startCheckpoints sets up checkpointing for this run and, with --resume,
+// loads the checkpoint of the last one.
+func startCheckpoints(srcRoot, dstRoot string) error {
+	if resumeFlag && orderFlag != orderDiscovery {
+		return errors.New("--resume only works with --order discovery")
+	}
+	if orderFlag != orderDiscovery || !applyFlag || checkpointFiles <= 0 && checkpointInterval <= 0 {
+		return nil
+	}
+	path, src, dst, err := checkpointPath(srcRoot, dstRoot)
+	if err != nil {
+		return err
+	}
+	if resumeFlag {
+		data, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			fmt.Fprintf(os.Stderr, "No checkpoint to resume from; starting from the beginning\n")
+		case err != nil:
+			return err
+		default:
+			var saved checkpointState
+			if err := json.Unmarshal(data, &saved); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			resumeAfter = saved.Last
+			fmt.Fprintf(os.Stderr, "Resuming after %s (checkpoint of %s)\n", filepath.FromSlash(saved.Last), saved.Time.Local().Format(time.DateTime))
+		}
+	}
+	checkpoints = &checkpointer{
+		path:    path,
+		state:   checkpointState{Source: src, Target: dst},
+		written: time.Now(),
+	}
+	return nil
+}
+
+// note records rel as done and saves a checkpoint when one is due. After
+// a failure the checkpoint stays put, so a resumed run retries that file.
+func (c *checkpointer) note(rel string) {
+	if c == nil || atomic.LoadInt64(&failed) > 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.Last = filepath.ToSlash(rel)
+	c.state.Files++
+	c.pending++
+	if checkpointFiles > 0 && c.pending >= checkpointFiles || checkpointInterval > 0 && time.Since(c.written) >= checkpointInterval {
+		c.save()
+	}
+}
+
+// flush saves the latest position of a run that didn't complete.
+func (c *checkpointer) flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending > 0 {
+		c.save()
+	}
+}
+
+// remove deletes the checkpoint of a run that completed.
+func (c *checkpointer) remove() {
+	if c == nil {
+		return
+	}
+	os.Remove(c.path)
+}
+
+// save writes the checkpoint; c.mu must be held. A failure only means a
+// longer resume, so it is reported and the run goes on.
+func (c *checkpointer) save() {
+	c.state.Time = time.Now().UTC()
+	data, err := json.Marshal(c.state)
+	if err == nil {
+		tmp := c.path + partSuffix
+		if err = os.WriteFile(tmp, data, 0o644); err == nil {
+			err = os.Rename(tmp, c.path)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot save checkpoint: %v\n", err)
+	}
+	c.pending = 0
+	c.written = time.Now()
+}
+
+// resumeSkip tells walkTransfers to pass over rel because the run being
+// resumed had got past it: files up to the checkpoint, and directories
+// that were finished before it.
+func resumeSkip(rel string, dir bool) bool {
+	if resumeAfter == "" {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if dir && strings.HasPrefix(resumeAfter, rel+"/") {
+		return false
+	}
+	return compareWalkOrder(rel, resumeAfter) <= 0
+}
+
+// compareWalkOrder compares slash-separated paths in the order WalkDir
+// visits them: name by name, so "a/b" comes before "a-c".
+func compareWalkOrder(a, b string) int {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := strings.Compare(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	return len(as) - len(bs)
+}
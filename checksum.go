@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	// hashFlag selects the checksum algorithm for every checksum feature.
+	hashFlag = "xxh3"
+	// verifyFlag re-reads each copy and compares checksums.
+	verifyFlag bool
+)
+
+// hashAlgorithms are the choices for --hash. xxh3 is fast and fine for
+// change detection; sha256 suits manifests others must be able to check.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"xxh3":   func() hash.Hash { return newXXH3() },
+	"blake3": newBLAKE3,
+	"sha256": sha256.New,
+	"md5":    md5.New,
+}
+
+var errVerifyFailed = errors.New("checksum mismatch after copy")
+
+// hashedBytes counts what has been read only to be checksummed, such as
+// the --verify re-read of a copy, which --stall-timeout counts as progress.
+var hashedBytes int64
+
+// hashedReader counts what is read through it in hashedBytes.
+type hashedReader struct {
+	r io.Reader
+}
+
+func (h hashedReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	atomic.AddInt64(&hashedBytes, int64(n))
+	return n, err
+}
+
+func validateHash(name string) error {
+	if _, ok := hashAlgorithms[name]; ok {
+		return nil
+	}
+	return fmt.Errorf("invalid --hash %q (want %s)", name, strings.Join(slices.Sorted(maps.Keys(hashAlgorithms)), ", "))
+}
+
+func newHash() hash.Hash {
+	return hashAlgorithms[hashFlag]()
+}
+
+// hashFile returns the hex checksum of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := openFiles.open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := newHash()
+	if _, err := copyData(h, hashedReader{f}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashWhileCopying reports whether copies should hash the source as it
+// streams past, so checksum features don't need to read it again.
+func hashWhileCopying() bool {
+	return verifyFlag || writeChecksumsFlag != ""
+}
+
+// verifyCopy checks that dst has the checksum want, as computed from the
+// source.
+func verifyCopy(dst, want string) error {
+	got, err := hashFile(dst)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("%s: %w (%s %s, expected %s)", dst, errVerifyFailed, hashFlag, got, want)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// chmodRule is one comma-separated item of a --chmod value: an octal mode
+// or a symbolic change in the style of chmod(1), limited to directories
+// (D prefix) or files (F prefix) if one is given.
+type chmodRule struct {
+	dirs, files bool
+	octal       bool
+	mode        fs.FileMode // the octal mode, or the bits to change
+	who         fs.FileMode // for symbolic rules, the u/g/o bits affected
+	op          byte        // '+', '-' or '='
+	condExec    bool        // X: execute only for directories or if already executable
+}
+
+// chmodRules rewrites target permissions (--chmod), in order.
+var chmodRules []chmodRule
+
+// chmodFlag adds --chmod rules; the flag can be repeated.
+type chmodFlag struct{}
+
+func (chmodFlag) String() string { return "" }
+
+func (chmodFlag) repeatable() {}
+
+func (chmodFlag) Set(v string) error {
+	for _, item := range strings.Split(v, ",") {
+		r, err := parseChmodRule(strings.TrimSpace(item))
+		if err != nil {
+			return err
+		}
+		chmodRules = append(chmodRules, r)
+	}
+	return nil
+}
+
+func parseChmodRule(s string) (chmodRule, error) {
+	r := chmodRule{dirs: true, files: true}
+	if strings.HasPrefix(s, "D") {
+		r.files, s = false, s[1:]
+	} else if strings.HasPrefix(s, "F") {
+		r.dirs, s = false, s[1:]
+	}
+
+	if n, err := strconv.ParseUint(s, 8, 32); err == nil && n <= 0o7777 {
+		r.octal = true
+		r.mode = fs.FileMode(n) & fs.ModePerm
+		if n&0o4000 != 0 {
+			r.mode |= fs.ModeSetuid
+		}
+		if n&0o2000 != 0 {
+			r.mode |= fs.ModeSetgid
+		}
+		if n&0o1000 != 0 {
+			r.mode |= fs.ModeSticky
+		}
+		return r, nil
+	}
+
+	i := strings.IndexAny(s, "+-=")
+	if i < 0 {
+		return r, fmt.Errorf("invalid --chmod %q", s)
+	}
+	for _, c := range s[:i] {
+		switch c {
+		case 'u':
+			r.who |= 0o700
+		case 'g':
+			r.who |= 0o070
+		case 'o':
+			r.who |= 0o007
+		case 'a':
+			r.who |= 0o777
+		default:
+			return r, fmt.Errorf("invalid --chmod %q", s)
+		}
+	}
+	if r.who == 0 {
+		r.who = 0o777
+	}
+	r.op = s[i]
+	for _, c := range s[i+1:] {
+		switch c {
+		case 'r':
+			r.mode |= 0o444 & r.who
+		case 'w':
+			r.mode |= 0o222 & r.who
+		case 'x':
+			r.mode |= 0o111 & r.who
+		case 'X':
+			r.condExec = true
+		case 's':
+			if r.who&0o700 != 0 {
+				r.mode |= fs.ModeSetuid
+			}
+			if r.who&0o070 != 0 {
+				r.mode |= fs.ModeSetgid
+			}
+		case 't':
+			r.mode |= fs.ModeSticky
+		default:
+			return r, fmt.Errorf("invalid --chmod %q", s)
+		}
+	}
+	return r, nil
+}
+
+// chmodMode returns mode after the --chmod rules for a directory or file.
+func chmodMode(mode fs.FileMode, isDir bool) fs.FileMode {
+	const bits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+	perm := mode & bits
+	for _, r := range chmodRules {
+		if isDir && !r.dirs || !isDir && !r.files {
+			continue
+		}
+		if r.octal {
+			perm = r.mode
+			continue
+		}
+		change := r.mode
+		if r.condExec && (isDir || perm&0o111 != 0) {
+			change |= 0o111 & r.who
+		}
+		switch r.op {
+		case '+':
+			perm |= change
+		case '-':
+			perm &^= change
+		case '=':
+			clear := r.who
+			if r.who&0o700 != 0 {
+				clear |= fs.ModeSetuid
+			}
+			if r.who&0o070 != 0 {
+				clear |= fs.ModeSetgid
+			}
+			if r.who&0o007 != 0 {
+				clear |= fs.ModeSticky
+			}
+			perm = perm&^clear | change
+		}
+	}
+	return mode&^bits | perm
+}
+
+// applyChmod sets the permissions --chmod gives a target entry whose mode
+// would otherwise be mode. It does nothing without --chmod.
+func applyChmod(path string, mode fs.FileMode) error {
+	if len(chmodRules) == 0 {
+		return nil
+	}
+	return os.Chmod(path, chmodMode(mode, mode.IsDir()))
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// sharedFlagCommands are subcommands that take the global flags, given
+// before or after the subcommand name, plus <source> <target> operands.
+var sharedFlagCommands = map[string]bool{
+	"copy":   true,
+	"move":   true,
+	"sync":   true,
+	"diff":   true,
+	"daemon": true,
+}
+
+var (
+	// commandArg is the index in os.Args of the subcommand name.
+	commandArg int
+	// daemonInterval is the pause between runs of "daemon".
+	daemonInterval time.Duration
+)
+
+// parseInterspersed parses args with fs, allowing flags and operands in
+// any order, and returns the operands.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var operands []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			exit(2)
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return operands
+		}
+		if args[0] == "--" {
+			return append(operands, args[1:]...)
+		}
+		operands = append(operands, args[0])
+		args = args[1:]
+	}
+}
+
+// runCommand runs one of the sharedFlagCommands.
+func runCommand(command string, operands []string) {
+	if len(operands) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [flags] <source> <target>\n", os.Args[0], command)
+		exit(1)
+	}
+	sourceFlag, targetFlag = operands[0], operands[1]
+	switch command {
+	case "copy":
+		copyFlag = true
+		runCopyMoveOperation()
+	case "sync":
+		copyFlag, deleteFlag = true, true
+		runCopyMoveOperation()
+	case "move":
+		moveFlag = true
+		runCopyMoveOperation()
+	case "diff":
+		runDiff()
+	case "daemon":
+		runDaemon()
+	}
+}
+
+// runDiff lists how the target differs from the source, comparing sizes
+// unless --verify asks for checksums, and exits non-zero if it does.
+func runDiff() {
+	srcRoot := filepath.Clean(sourceFlag)
+	dstRoot := filepath.Clean(targetFlag)
+	problems, checked, err := verifyTrees(srcRoot, dstRoot, !verifyFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if problems > 0 {
+		fmt.Printf("%d of %d entries differ\n", problems, checked)
+		exit(1)
+	}
+	fmt.Printf("No differences in %d entries\n", checked)
+}
+
+// runDaemon keeps the target up to date by running "copy --apply" with
+// the same flags every --interval until interrupted. Each run is a child
+// process, so one that fails or exits doesn't stop the daemon. With
+// --control-socket, each run gets its own socket next to the daemon's.
+// Under systemd (Type=notify) it reports readiness and status and pings
+// the watchdog; SIGTERM lets the current run abort cleanly before the
+// daemon exits.
+func runDaemon() {
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	args := slices.Clone(os.Args)
+	args[0], args[commandArg] = "--apply", "copy"
+
+	var ctl *daemonControl
+	var wake chan struct{}
+	if controlSocketFlag != "" {
+		ctl = newDaemonControl(controlSocketFlag)
+		wake = ctl.wake
+		if err := serveControl(controlSocketFlag, ctl.handle); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot open control socket: %v\n", err)
+			exit(1)
+		}
+		args = append(args, "--control-socket="+ctl.childPath)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	startWatchdog()
+	sdNotify("READY=1")
+	for {
+		if ctl == nil || !ctl.isPaused() {
+			started := time.Now()
+			sdNotify("STATUS=Running " + sourceFlag + " -> " + targetFlag)
+			if ctl != nil {
+				ctl.setRunning(true, "")
+			}
+			result, stopping := runDaemonChild(exec.Command(exe, args...), sigs)
+			if ctl != nil {
+				ctl.setRunning(false, result)
+			}
+			if runLog != nil {
+				runLog.printf("Daemon run finished in %s: %s", time.Since(started).Round(time.Second), result)
+			}
+			if stopping {
+				exit(0)
+			}
+		}
+		next := time.Now().Add(daemonInterval)
+		if ctl != nil {
+			ctl.setNextRun(next)
+		}
+		sdNotify("STATUS=Idle, next run at " + next.Format(time.TimeOnly))
+		fmt.Fprintf(os.Stderr, "Next run in %s\n", daemonInterval)
+		select {
+		case <-time.After(daemonInterval):
+		case <-wake:
+		case <-sigs:
+			sdNotify("STOPPING=1")
+			exit(0)
+		}
+	}
+}
+
+// runDaemonChild runs one copy and returns its result. A signal on sigs
+// is passed on to the run, which aborts and cleans up; stopping is then
+// true.
+func runDaemonChild(cmd *exec.Cmd, sigs <-chan os.Signal) (result string, stopping bool) {
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err.Error(), false
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	var err error
+	select {
+	case err = <-done:
+	case sig := <-sigs:
+		stopping = true
+		sdNotify("STOPPING=1")
+		fmt.Fprintf(os.Stderr, "Stopping: waiting for the current run to abort\n")
+		if cmd.Process.Signal(sig) != nil {
+			cmd.Process.Kill()
+		}
+		err = <-done
+	}
+	if err != nil {
+		return err.Error(), stopping
+	}
+	return "ok", stopping
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Policies accepted by --on-collision.
+const (
+	collisionSkip   = "skip"
+	collisionRename = "rename"
+)
+
+// onCollisionFlag says what to do when a file exists on the target and
+// differs from the source: skip it, or copy under a new "name (1).ext".
+var onCollisionFlag = collisionSkip
+
+func validateCollision(policy string) error {
+	switch policy {
+	case collisionSkip, collisionRename:
+		return nil
+	}
+	return fmt.Errorf("invalid --on-collision %q (want skip or rename)", policy)
+}
+
+// collisionTarget finds where a source file that collides with a
+// different target file dst goes: the first free "name (n).ext". It
+// reports false if one of the numbered names already holds the same
+// file, from an earlier run.
+func collisionTarget(src fs.FileInfo, dst string) (string, bool) {
+	dir, name := filepath.Split(dst)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for n := 1; ; n++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, n, ext))
+		info, err := os.Lstat(candidate)
+		if err != nil {
+			return candidate, true
+		}
+		if info.Mode().IsRegular() && !changedFile(src, info) {
+			return "", false
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiBlue   = "\x1b[34m"
+	ansiCyan   = "\x1b[36m"
+)
+
+var noColorFlag bool
+
+// tagColors colors the [TAG] that starts an operation line.
+var tagColors = map[string]string{
+	"COPY":   ansiGreen,
+	"APPEND": ansiGreen,
+	"UPDATE": ansiGreen,
+	"MOVE":   ansiCyan,
+	"LINK":   ansiCyan,
+	"RENAME": ansiCyan,
+	"SKIP":   ansiYellow,
+	"IDLE":   ansiYellow,
+	"POWER":  ansiYellow,
+	"DELETE": ansiRed,
+	"RMDIR":  ansiRed,
+	"ERROR":  ansiRed,
+}
+
+// useColor reports whether w is a terminal that should get ANSI colors.
+// --no-color, a non-empty NO_COLOR (https://no-color.org) and TERM=dumb
+// all turn color off, as does output that isn't a terminal.
+func useColor(w io.Writer) bool {
+	if noColorFlag || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func colorize(w io.Writer, color, s string) string {
+	if !useColor(w) {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+// colorTag colors the leading [TAG] of line, if it has a known one.
+func colorTag(w io.Writer, line string) string {
+	if !strings.HasPrefix(line, "[") {
+		return line
+	}
+	end := strings.IndexByte(line, ']')
+	if end < 0 {
+		return line
+	}
+	color, ok := tagColors[line[1:end]]
+	if !ok {
+		return line
+	}
+	return colorize(w, color, line[:end+1]) + line[end+1:]
+}
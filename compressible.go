@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"math"
+)
+
+// compressedMagic are the leading bytes of formats that are already
+// compressed, where deflating again only costs CPU.
+var compressedMagic = [][]byte{
+	{0xFF, 0xD8, 0xFF},                      // JPEG
+	{0x89, 'P', 'N', 'G'},                   // PNG
+	[]byte("GIF8"),                          // GIF
+	{'P', 'K', 0x03, 0x04},                  // zip, docx, epub, jar
+	{0x1F, 0x8B},                            // gzip
+	{0x28, 0xB5, 0x2F, 0xFD},                // zstd
+	{0xFD, '7', 'z', 'X', 'Z', 0x00},        // xz
+	[]byte("BZh"),                           // bzip2
+	{'7', 'z', 0xBC, 0xAF, 0x27, 0x1C},      // 7-Zip
+	[]byte("Rar!"),                          // RAR
+	{0x1A, 0x45, 0xDF, 0xA3},                // Matroska, WebM
+	[]byte("OggS"),                          // Ogg
+	[]byte("fLaC"),                          // FLAC
+	[]byte("ID3"),                           // MP3 with ID3 tag
+	{0x00, 0x00, 0x00, 0x0C, 'j', 'P', ' '}, // JPEG 2000
+}
+
+// compressedFormat reports whether data, the start of a file, looks like
+// an already-compressed format.
+func compressedFormat(data []byte) bool {
+	for _, magic := range compressedMagic {
+		if bytes.HasPrefix(data, magic) {
+			return true
+		}
+	}
+	// ISO media (MP4, MOV, HEIC, AVIF) has "ftyp" after the box size;
+	// RIFF (WebP, AVI) names the format after the chunk size
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" {
+		return true
+	}
+	if len(data) >= 12 && string(data[:4]) == "RIFF" {
+		switch string(data[8:12]) {
+		case "WEBP", "AVI ":
+			return true
+		}
+	}
+	return false
+}
+
+// entropySample is how much of a chunk highEntropy looks at.
+const entropySample = 64 << 10
+
+// highEntropy reports whether a sample of data is so close to random
+// (over 7.5 bits per byte) that compressing it would gain next to nothing.
+func highEntropy(data []byte) bool {
+	if len(data) > entropySample {
+		data = data[:entropySample]
+	}
+	if len(data) < 1024 {
+		return false
+	}
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	var bits float64
+	n := float64(len(data))
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / n
+			bits -= p * math.Log2(p)
+		}
+	}
+	return bits > 7.5
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+)
+
+var (
+	errAborted = errors.New("operation aborted")
+	errSkipped = errors.New("file skipped")
+)
+
+// runControl lets interactive front-ends pause, skip or abort a running
+// copy/move. The transfer loop polls it between files and while streaming.
+// Monitors such as --only-when-idle hold the run for a reason of their
+// own, independently of a pause by the user.
+type runControl struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	paused  bool
+	skip    bool
+	aborted bool
+	// abortErr is what checkpoint returns once aborted.
+	abortErr error
+	holds    map[string]bool
+}
+
+var control = newRunControl()
+
+func newRunControl() *runControl {
+	c := &runControl{}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// checkpoint blocks while the run is paused and reports whether the current
+// file should be skipped or the whole run aborted.
+func (c *runControl) checkpoint() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for (c.paused || len(c.holds) > 0) && !c.aborted && !c.skip {
+		c.cond.Wait()
+	}
+	if c.aborted {
+		return cmp.Or(c.abortErr, errAborted)
+	}
+	if c.skip {
+		c.skip = false
+		return errSkipped
+	}
+	return nil
+}
+
+// wait blocks while the run is paused or held and reports an abort.
+// Unlike checkpoint it leaves a skip request alone: deletions, which
+// --delete-during runs alongside the transfers, can't be skipped, and the
+// request is meant for the file being transferred.
+func (c *runControl) wait() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for (c.paused || len(c.holds) > 0) && !c.aborted {
+		c.cond.Wait()
+	}
+	if c.aborted {
+		return cmp.Or(c.abortErr, errAborted)
+	}
+	return nil
+}
+
+func (c *runControl) togglePause() {
+	c.mu.Lock()
+	c.paused = !c.paused
+	c.mu.Unlock()
+	c.cond.Broadcast()
+}
+
+func (c *runControl) setPaused(paused bool) {
+	c.mu.Lock()
+	c.paused = paused
+	c.mu.Unlock()
+	c.cond.Broadcast()
+}
+
+// hold stops the run at the next checkpoint until it is released with
+// on=false for the same reason.
+func (c *runControl) hold(reason string, on bool) {
+	c.mu.Lock()
+	if on {
+		if c.holds == nil {
+			c.holds = make(map[string]bool)
+		}
+		c.holds[reason] = true
+	} else {
+		delete(c.holds, reason)
+	}
+	c.mu.Unlock()
+	c.cond.Broadcast()
+}
+
+// heldBy lists the reasons the run is currently held for.
+func (c *runControl) heldBy() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Sorted(maps.Keys(c.holds))
+}
+
+func (c *runControl) skipCurrent() {
+	c.mu.Lock()
+	c.skip = true
+	c.mu.Unlock()
+	c.cond.Broadcast()
+}
+
+func (c *runControl) abort() {
+	c.abortWith(errAborted)
+}
+
+// abortWith aborts the run with err, which should wrap errAborted. The
+// first reason given sticks.
+func (c *runControl) abortWith(err error) {
+	c.mu.Lock()
+	if !c.aborted {
+		c.aborted, c.abortErr = true, err
+	}
+	c.mu.Unlock()
+	c.cond.Broadcast()
+}
+
+func (c *runControl) isPaused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+func (c *runControl) isAborted() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.aborted
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// controlSocketFlag is the path of a unix-domain socket (also available on
+// Windows 10 and later) that accepts newline-delimited JSON-RPC 2.0
+// requests: status, pause, resume, cancel and, for the daemon,
+// reload-config.
+var controlSocketFlag string
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcNoMethod       = -32601
+	rpcServerError    = -32000
+)
+
+// rpcHandler answers one control method.
+type rpcHandler func(method string) (any, *rpcError)
+
+// serveControl listens on the control socket at path and answers requests
+// with handle until the process exits. A stale socket left by a crashed
+// run is replaced; one that still answers is an error. Under systemd
+// socket activation the socket named "control" is used instead.
+func serveControl(path string, handle rpcHandler) error {
+	ln, err := listenControl(path)
+	if err != nil {
+		return err
+	}
+	exitHooks = append(exitHooks, func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveControlConn(conn, handle)
+		}
+	}()
+	return nil
+}
+
+func listenControl(path string) (net.Listener, error) {
+	if ln := activatedListener("control"); ln != nil {
+		return ln, nil
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another run", path)
+		}
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	os.Chmod(path, 0o600)
+	return ln, nil
+}
+
+func serveControlConn(conn net.Conn, handle rpcHandler) {
+	defer conn.Close()
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		var req rpcRequest
+		resp := rpcResponse{JSONRPC: "2.0"}
+		if err := dec.Decode(&req); err != nil {
+			var syntax *json.SyntaxError
+			if !errors.As(err, &syntax) {
+				return
+			}
+			resp.Error = &rpcError{rpcParseError, "parse error"}
+			enc.Encode(resp)
+			return
+		}
+		resp.ID = req.ID
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			resp.Error = &rpcError{rpcInvalidRequest, "invalid request"}
+		} else {
+			resp.Result, resp.Error = handle(req.Method)
+		}
+		if req.ID == nil {
+			continue // a notification gets no reply
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// callControl sends method to the control socket at path and returns the
+// raw result.
+func callControl(path, method string) (json.RawMessage, *rpcError) {
+	conn, err := net.DialTimeout("unix", path, netTimeout)
+	if err != nil {
+		return nil, &rpcError{rpcServerError, err.Error()}
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(netTimeout))
+	req := map[string]any{"jsonrpc": "2.0", "id": 1, "method": method}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, &rpcError{rpcServerError, err.Error()}
+	}
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, &rpcError{rpcServerError, err.Error()}
+	}
+	return resp.Result, resp.Error
+}
+
+// runControlMethod answers control requests for a single copy/move run.
+func runControlMethod(method string) (any, *rpcError) {
+	switch method {
+	case "status":
+	case "pause":
+		control.setPaused(true)
+	case "resume":
+		control.setPaused(false)
+	case "cancel":
+		control.abort()
+	case "reload-config":
+		return nil, &rpcError{rpcServerError, "reload-config is only supported by the daemon"}
+	default:
+		return nil, &rpcError{rpcNoMethod, "method not found: " + method}
+	}
+	return status.snapshot(), nil
+}
+
+// daemonControl is the state behind the daemon's control socket. While a
+// run is in progress, requests are passed on to the child's own socket.
+type daemonControl struct {
+	mu         sync.Mutex
+	running    bool
+	paused     bool
+	nextRun    time.Time
+	lastResult string
+	childPath  string
+	wake       chan struct{}
+}
+
+// daemonStatus is the result of "status" on the daemon's socket.
+type daemonStatus struct {
+	State      string          `json:"state"`
+	NextRun    *time.Time      `json:"nextRun,omitempty"`
+	LastResult string          `json:"lastResult,omitempty"`
+	Run        json.RawMessage `json:"run,omitempty"`
+}
+
+func newDaemonControl(path string) *daemonControl {
+	return &daemonControl{childPath: path + ".run", wake: make(chan struct{}, 1)}
+}
+
+// handle answers a control request. pause and resume also hold or release
+// the runs to come; cancel stops only the current run; reload-config
+// starts the next run now, so it picks up changed filter lists,
+// certificates and other files the flags point to.
+func (d *daemonControl) handle(method string) (any, *rpcError) {
+	d.mu.Lock()
+	switch method {
+	case "status", "cancel":
+	case "pause":
+		d.paused = true
+	case "resume":
+		d.paused = false
+		d.poke()
+	case "reload-config":
+		if !d.running {
+			d.poke()
+		}
+	default:
+		d.mu.Unlock()
+		return nil, &rpcError{rpcNoMethod, "method not found: " + method}
+	}
+	running := d.running
+	d.mu.Unlock()
+
+	var run json.RawMessage
+	if running && method != "reload-config" {
+		var err *rpcError
+		if run, err = callControl(d.childPath, method); err != nil {
+			return nil, err
+		}
+	}
+	return d.status(run), nil
+}
+
+// poke wakes the daemon loop; d.mu must be held.
+func (d *daemonControl) poke() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *daemonControl) status(run json.RawMessage) daemonStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := daemonStatus{State: "idle", LastResult: d.lastResult, Run: run}
+	switch {
+	case d.running:
+		s.State = "running"
+	case d.paused:
+		s.State = "paused"
+	}
+	if !d.running && !d.nextRun.IsZero() {
+		next := d.nextRun
+		s.NextRun = &next
+	}
+	return s
+}
+
+func (d *daemonControl) setRunning(running bool, result string) {
+	d.mu.Lock()
+	d.running = running
+	if !running {
+		d.lastResult = result
+	}
+	d.mu.Unlock()
+}
+
+func (d *daemonControl) isPaused() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.paused
+}
+
+func (d *daemonControl) setNextRun(t time.Time) {
+	d.mu.Lock()
+	d.nextRun = t
+	d.mu.Unlock()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// credentialHelper names a git-style credential helper that supplies the
+// SMTP password, so it need not sit in the environment or a unit file. A
+// bare name runs git-credential-<name>, which covers the OS keychains:
+// "osxkeychain" (macOS Keychain), "manager" (Windows Credential Manager)
+// and "libsecret" (secret-service). Anything else is run by the shell.
+// Store the password once with, for example:
+//
+//	printf 'protocol=smtp\nhost=mail.example.com\nusername=me\npassword=...\n' | git credential-osxkeychain store
+var credentialHelper string
+
+// useCredentialHelper fills in the SMTP password from --credential-helper
+// when a user is set and no password was given.
+func useCredentialHelper() error {
+	if credentialHelper == "" || !mailConfig.enabled() || mailConfig.user == "" || mailConfig.password != "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(mailConfig.addr)
+	if err != nil {
+		return fmt.Errorf("invalid --smtp-host %q: %v", mailConfig.addr, err)
+	}
+	password, err := lookupCredential(credentialHelper, "smtp", host, mailConfig.user)
+	if err != nil {
+		return err
+	}
+	mailConfig.password = password
+	return nil
+}
+
+// lookupCredential asks helper for a password using git's credential
+// helper protocol: key=value lines on stdin for "get", password=... on
+// stdout.
+func lookupCredential(helper, protocol, host, user string) (string, error) {
+	var cmd *exec.Cmd
+	switch {
+	case !strings.ContainsAny(helper, " \t/\\"):
+		cmd = exec.Command("git-credential-"+helper, "get")
+	case runtime.GOOS == "windows":
+		cmd = exec.Command("cmd", "/C", helper+" get")
+	default:
+		cmd = exec.Command("sh", "-c", helper+" get")
+	}
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=%s\nhost=%s\nusername=%s\n\n", protocol, host, user))
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("credential helper %q: %w", helper, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if password, ok := strings.CutPrefix(scanner.Text(), "password="); ok {
+			return strings.TrimSuffix(password, "\r"), nil
+		}
+	}
+	return "", fmt.Errorf("credential helper %q has no password for %s@%s", helper, user, host)
+}
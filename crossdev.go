@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// moveAcrossDevices moves a file that can't be renamed because source and
+// target are on different filesystems: it copies it, checks the copy and
+// only then removes the source, so the one good copy is never lost. The
+// size is always checked; with --verify streamFile has also compared
+// checksums before the copy got its final name.
+func moveAcrossDevices(ctx context.Context, src, dst, relPath string, info os.FileInfo, replace bool) error {
+	if screen != nil {
+		screen.setFile(relPath, info.Size())
+	}
+	progress := &progressWriter{fileName: filepath.Base(src), total: info.Size(), ctx: ctx}
+	if _, err := streamFile(src, dst, info.Mode(), replace, progress); err != nil {
+		atomic.AddInt64(&overallProgress, -atomic.LoadInt64(&progress.current))
+		return err
+	}
+	endProgressLine()
+
+	copied, err := os.Stat(dst)
+	if err != nil {
+		return err
+	}
+	if copied.Size() != info.Size() {
+		os.Remove(dst)
+		return fmt.Errorf("%s: copy has %d bytes, source %d; source kept", dst, copied.Size(), info.Size())
+	}
+	// Journaled as a rename so undo moves it back rather than deleting it
+	if undoLog != nil {
+		if err := undoLog.record(undoEntry{Op: "rename", From: src, Path: dst}); err != nil {
+			return err
+		}
+	}
+	fileOps.wait()
+	return os.Remove(src)
+}
+
+// moveAcross is the plain version for undo: copy from to to, keeping the
+// mode and mtime, then remove from.
+func moveAcross(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(to, os.O_CREATE|os.O_WRONLY|os.O_EXCL, info.Mode())
+	if err != nil {
+		return err
+	}
+	_, err = copyData(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(to, time.Time{}, info.ModTime())
+	}
+	if err != nil {
+		os.Remove(to)
+		return err
+	}
+	return os.Remove(from)
+}
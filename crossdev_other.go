@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package main
+
+// isCrossDevice can't tell here; renames that fail are reported as is.
+func isCrossDevice(err error) bool {
+	return false
+}
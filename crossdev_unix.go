@@ -0,0 +1,14 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isCrossDevice reports whether a rename failed because the paths are on
+// different filesystems.
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errorNotSameDevice is ERROR_NOT_SAME_DEVICE.
+const errorNotSameDevice = syscall.Errno(17)
+
+// isCrossDevice reports whether a rename failed because the paths are on
+// different volumes.
+func isCrossDevice(err error) bool {
+	return errors.Is(err, errorNotSameDevice)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"runtime/trace"
+)
+
+// exitHooks run before the process exits so profiles and traces are flushed
+// even on the error paths that call exit directly.
+var exitHooks []func()
+
+func exit(code int) {
+	for i := len(exitHooks) - 1; i >= 0; i-- {
+		exitHooks[i]()
+	}
+	os.Exit(code)
+}
+
+// startPprof serves the net/http/pprof handlers on addr in the background.
+// An address without a host, such as ":6060", only listens on localhost;
+// the handlers expose memory and goroutine dumps, so other machines only
+// reach them when a host is given.
+func startPprof(addr string) error {
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "pprof listening on http://%s/debug/pprof/\n", ln.Addr())
+	go http.Serve(ln, http.DefaultServeMux)
+	return nil
+}
+
+// startTrace records a runtime execution trace to path until exit.
+func startTrace(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := trace.Start(f); err != nil {
+		f.Close()
+		return err
+	}
+	exitHooks = append(exitHooks, func() {
+		trace.Stop()
+		f.Close()
+	})
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// With backup --dedup, file contents go to a content-addressed chunk
+// store shared by all sets instead of each set's data/ directory. Files
+// are cut with FastCDC content-defined chunking, so an edit in the middle
+// of a large file only changes the chunks around it, and a chunk already
+// in the store is never written twice.
+const backupChunks = "chunks"
+
+// FastCDC parameters: chunks are 256K to 4M and average about 1M.
+const (
+	cdcMinSize = 256 << 10
+	cdcAvgBits = 20
+	cdcMaxSize = 4 << 20
+)
+
+var (
+	// Normalized chunking: a stricter mask below the average size and a
+	// looser one above it pull chunk sizes towards the average.
+	cdcMaskSmall = cdcMask(cdcAvgBits + 2)
+	cdcMaskLarge = cdcMask(cdcAvgBits - 2)
+	cdcGear      = gearTable()
+)
+
+// Chunk files start with one byte saying how the rest is stored.
+const (
+	chunkRaw     = 0
+	chunkDeflate = 1
+)
+
+// cdcMask sets the top bits bits of the mask; the gear hash mixes the
+// most recent bytes into its high bits.
+func cdcMask(bits int) uint64 {
+	return ^uint64(0) << (64 - bits)
+}
+
+// gearTable fills the gear hash table from a fixed seed (splitmix64), so
+// every build cuts the same content at the same places.
+func gearTable() [256]uint64 {
+	var table [256]uint64
+	x := uint64(0x6c7970686f746f73)
+	for i := range table {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		table[i] = z ^ z>>31
+	}
+	return table
+}
+
+// cdcCut returns the length of the first chunk of data, which holds as
+// much of the stream as is available up to cdcMaxSize.
+func cdcCut(data []byte) int {
+	n := len(data)
+	if n <= cdcMinSize {
+		return n
+	}
+	if n > cdcMaxSize {
+		n = cdcMaxSize
+	}
+	normal := min(1<<cdcAvgBits, n)
+	var fp uint64
+	i := cdcMinSize
+	for ; i < normal; i++ {
+		fp = fp<<1 + cdcGear[data[i]]
+		if fp&cdcMaskSmall == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = fp<<1 + cdcGear[data[i]]
+		if fp&cdcMaskLarge == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// chunker splits a stream into content-defined chunks.
+type chunker struct {
+	r   io.Reader
+	buf []byte
+	n   int
+	eof bool
+}
+
+func newChunker(r io.Reader) *chunker {
+	return &chunker{r: r, buf: make([]byte, cdcMaxSize)}
+}
+
+// next returns the next chunk, valid until the following call, or io.EOF.
+func (c *chunker) next() ([]byte, error) {
+	if !c.eof && c.n < len(c.buf) {
+		m, err := io.ReadFull(c.r, c.buf[c.n:])
+		c.n += m
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if c.n == 0 {
+		return nil, io.EOF
+	}
+	cut := cdcCut(c.buf[:c.n])
+	chunk := bytes.Clone(c.buf[:cut])
+	c.n = copy(c.buf, c.buf[cut:c.n])
+	return chunk, nil
+}
+
+func chunkPath(backupRoot, id string) string {
+	return filepath.Join(backupRoot, backupChunks, id[:2], id)
+}
+
+// storeChunks writes the chunks of path that the store doesn't have yet
+// and returns the file's chunk IDs and the bytes added to the store.
+// Files in an already-compressed format, and chunks that look random, are
+// stored without trying to compress them.
+func storeChunks(backupRoot, path string, compress bool) (ids []string, stored int64, err error) {
+	f, err := openFiles.open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	c := newChunker(f)
+	ids = []string{}
+	for {
+		chunk, err := c.next()
+		if errors.Is(err, io.EOF) {
+			return ids, stored, nil
+		}
+		if err != nil {
+			return nil, stored, err
+		}
+		if len(ids) == 0 && compressedFormat(chunk) {
+			compress = false
+		}
+		sum := sha256.Sum256(chunk)
+		id := hex.EncodeToString(sum[:])
+		ids = append(ids, id)
+		n, err := writeChunkFile(chunkPath(backupRoot, id), chunk, compress && !highEntropy(chunk))
+		if err != nil {
+			return nil, stored, err
+		}
+		stored += n
+	}
+}
+
+// writeChunkFile stores chunk at path unless it is already there. It is
+// compressed only if that makes it smaller.
+func writeChunkFile(path string, chunk []byte, compress bool) (int64, error) {
+	if exists(path) {
+		return 0, nil
+	}
+	data := append([]byte{chunkRaw}, chunk...)
+	if compress {
+		var buf bytes.Buffer
+		buf.WriteByte(chunkDeflate)
+		zw, _ := flate.NewWriter(&buf, flate.BestSpeed)
+		zw.Write(chunk)
+		zw.Close()
+		if buf.Len() < len(data) {
+			data = buf.Bytes()
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return 0, err
+	}
+	tmp := path + partSuffix
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return int64(len(data)), os.Rename(tmp, path)
+}
+
+// readChunkFile loads chunk id from the store and checks its content.
+func readChunkFile(backupRoot, id string) ([]byte, error) {
+	data, err := os.ReadFile(chunkPath(backupRoot, id))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("chunk %s is empty", id)
+	}
+	chunk := data[1:]
+	switch data[0] {
+	case chunkRaw:
+	case chunkDeflate:
+		if chunk, err = io.ReadAll(flate.NewReader(bytes.NewReader(chunk))); err != nil {
+			return nil, fmt.Errorf("chunk %s: %w", id, err)
+		}
+	default:
+		return nil, fmt.Errorf("chunk %s: unknown encoding %d", id, data[0])
+	}
+	if sum := sha256.Sum256(chunk); hex.EncodeToString(sum[:]) != id {
+		return nil, fmt.Errorf("chunk %s is corrupt", id)
+	}
+	return chunk, nil
+}
+
+// restoreChunks reassembles e from the chunk store at dst.
+func restoreChunks(backupRoot string, e backupEntry, dst string) error {
+	if err := ensureDir(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	part := partPath(dst)
+	out, err := openFiles.openFile(part, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, e.Mode.Perm())
+	if err != nil {
+		return err
+	}
+	w := throttle(out)
+	for _, id := range e.Chunks {
+		var chunk []byte
+		if chunk, err = readChunkFile(backupRoot, id); err != nil {
+			break
+		}
+		if _, err = w.Write(chunk); err != nil {
+			break
+		}
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		mtime := time.Unix(0, e.MTimeNs)
+		err = os.Chtimes(part, mtime, mtime)
+	}
+	if err == nil {
+		err = os.Rename(part, dst)
+	}
+	if err != nil {
+		os.Remove(part)
+	}
+	return err
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Deletion timings for --delete-before, --delete-during and --delete-after.
+const (
+	deleteBefore = "before"
+	deleteDuring = "during"
+	deleteAfter  = "after"
+)
+
+var (
+	// deleteFlag removes target entries the source doesn't have.
+	deleteFlag bool
+	// deleteExcludedFlag also removes target entries that are excluded,
+	// which --delete otherwise leaves alone.
+	deleteExcludedFlag bool
+	// deleteBeforeFlag, deleteDuringFlag and deleteAfterFlag pick when
+	// deletions happen; each implies --delete.
+	deleteBeforeFlag, deleteDuringFlag, deleteAfterFlag bool
+	// deleteTiming is the resolved choice, after by default.
+	deleteTiming = deleteAfter
+	// maxDeleteFlag caps how many target files --delete may remove, as a
+	// count or a percentage of the target.
+	maxDeleteFlag string
+	// deleted counts files removed (or, in preview, to be removed).
+	deleted int64
+)
+
+// resolveDeleteTiming folds the timing flags into deleteTiming. Deleting
+// before frees space for tight disks; after (the default) only deletes
+// once every copy has succeeded.
+func resolveDeleteTiming() error {
+	var chosen []string
+	for _, t := range []struct {
+		set    bool
+		timing string
+	}{{deleteBeforeFlag, deleteBefore}, {deleteDuringFlag, deleteDuring}, {deleteAfterFlag, deleteAfter}} {
+		if t.set {
+			chosen = append(chosen, t.timing)
+		}
+	}
+	if len(chosen) > 1 {
+		return fmt.Errorf("only one of --delete-before, --delete-during and --delete-after can be given")
+	}
+	if len(chosen) == 1 {
+		deleteFlag, deleteTiming = true, chosen[0]
+	}
+	return nil
+}
+
+// deleteExtraneous walks dstRoot and removes every entry that has no
+// counterpart in srcRoot, whole directories at a time.
+func deleteExtraneous(srcRoot, dstRoot string) error {
+	filters.useRoot(srcRoot)
+	return filepath.WalkDir(dstRoot, func(path string, d fs.DirEntry, err error) error {
+		rel, _ := filepath.Rel(dstRoot, path)
+		if err != nil {
+			if d == nil || handleFailure(rel, err) != nil {
+				return err
+			}
+			return skipEntry(d)
+		}
+		if rel == "." {
+			return nil
+		}
+		descend, err := pruneEntry(srcRoot, dstRoot, rel, d)
+		if err != nil || descend {
+			return err
+		}
+		return skipEntry(d)
+	})
+}
+
+// deleteInDir removes the entries directly inside the target directory
+// rel that the source lacks. --delete-during calls it for each directory
+// as the transfer walk reaches it.
+func deleteInDir(srcRoot, dstRoot, rel string) error {
+	entries, err := os.ReadDir(filepath.Join(dstRoot, rel))
+	if err != nil {
+		return handleFailure(rel, err)
+	}
+	for _, d := range entries {
+		if _, err := pruneEntry(srcRoot, dstRoot, filepath.Join(rel, d.Name()), d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneEntry deletes the target entry rel if the source has no
+// counterpart, and reports whether a walk should go on into it. Excluded
+// entries are protected unless --delete-excluded is set. Removals go
+// through the undo journal when there is one.
+func pruneEntry(srcRoot, dstRoot, rel string, d fs.DirEntry) (descend bool, err error) {
+	remove, descend, err := extraneous(srcRoot, rel, d)
+	if !remove || err != nil {
+		return descend, err
+	}
+
+	return false, deleteEntry(filepath.Join(dstRoot, rel), rel, d.IsDir())
+}
+
+// deleteEntry removes the target file or directory tree at path, or only
+// lists it in preview mode, and counts the files it held.
+func deleteEntry(path, rel string, dir bool) error {
+	files := int64(1)
+	name := rel
+	if dir {
+		files = countFiles(path)
+		name += string(filepath.Separator)
+	}
+	logDelete(name)
+	if applyFlag {
+		if err := control.wait(); err != nil {
+			return err
+		}
+		fileOps.wait()
+		if err := removeTree(path); err != nil {
+			return handleFailure(rel, err)
+		}
+	}
+	atomic.AddInt64(&deleted, files)
+	return nil
+}
+
+// extraneous reports whether the target entry rel is to be deleted and,
+// if not, whether a walk should go on into it.
+func extraneous(srcRoot, rel string, d fs.DirEntry) (remove, descend bool, err error) {
+	if d.IsDir() && d.Name() == undoDirName {
+		return false, false, nil
+	}
+	if !d.IsDir() && strings.HasSuffix(d.Name(), partSuffix) {
+		return false, false, nil
+	}
+	if versionsFlag > 0 && !d.IsDir() && versionSuffix.MatchString(d.Name()) {
+		return false, false, nil
+	}
+	if writeChecksumsFlag != "" && rel == filepath.Clean(writeChecksumsFlag) {
+		return false, false, nil
+	}
+	if excluded(rel, d.IsDir()) {
+		return deleteExcludedFlag, false, nil
+	}
+	if d.IsDir() {
+		if _, large := dirTooLarge(filepath.Join(srcRoot, rel), rel); large {
+			return deleteExcludedFlag, false, nil
+		}
+	}
+	if splitCounterpart(srcRoot, rel) {
+		return false, false, nil
+	}
+	if _, err := os.Lstat(filepath.Join(srcRoot, rel)); err == nil {
+		return false, true, nil
+	} else if !os.IsNotExist(err) {
+		return false, false, handleFailure(rel, err)
+	}
+	return true, false, nil
+}
+
+// checkMaxDelete counts what --delete would remove and refuses the run,
+// before anything is touched, when that is more than --max-delete allows.
+// An empty or mistyped source would otherwise wipe the target.
+func checkMaxDelete(srcRoot, dstRoot string) error {
+	if maxDeleteFlag == "" {
+		return nil
+	}
+	limit, pct, err := parseMaxDelete(maxDeleteFlag)
+	if err != nil {
+		return err
+	}
+
+	filters.useRoot(srcRoot)
+	var extra, total int64
+	err = filepath.WalkDir(dstRoot, func(path string, d fs.DirEntry, err error) error {
+		rel, _ := filepath.Rel(dstRoot, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		remove, descend, err := extraneous(srcRoot, rel, d)
+		if err != nil {
+			return err
+		}
+		if remove || !descend {
+			n := int64(1)
+			if d.IsDir() {
+				n = countFiles(path)
+			}
+			if d.Name() != undoDirName {
+				total += n
+			}
+			if remove {
+				extra += n
+			}
+			return skipEntry(d)
+		}
+		if !d.IsDir() {
+			total++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if pct {
+		limit = total * limit / 100
+	}
+	if extra > limit {
+		return fmt.Errorf("refusing to delete %d of %d target files, more than --max-delete %s allows", extra, total, maxDeleteFlag)
+	}
+	return nil
+}
+
+// parseMaxDelete reads a --max-delete value: a file count or a percentage
+// of the target's files ("10%").
+func parseMaxDelete(s string) (limit int64, pct bool, err error) {
+	num, pct := strings.CutSuffix(strings.TrimSpace(s), "%")
+	limit, err = strconv.ParseInt(num, 10, 64)
+	if err != nil || limit < 0 || pct && limit > 100 {
+		return 0, false, fmt.Errorf("invalid --max-delete %q: want a file count or a percentage", s)
+	}
+	return limit, pct, nil
+}
+
+// skipEntry is the walk result that moves past d without entering it.
+func skipEntry(d fs.DirEntry) error {
+	if d.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// countFiles counts the non-directories under dir.
+func countFiles(dir string) int64 {
+	var n int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return nil
+	})
+	return n
+}
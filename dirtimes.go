@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// restoreDirTimes gives every target directory the timestamps of its
+// source directory, as --times asks, and its --fileflags, --lsattr and
+// --win-attrs attributes. Writing into a directory moves its mtime, so
+// this runs once everything else is done, deepest first.
+func restoreDirTimes(srcRoot, dstRoot string) error {
+	if timesFlag == timesNone && !fileFlagsFlag && !lsattrFlag && !winAttrsFlag {
+		return nil
+	}
+	var dirs []string
+	filters.useRoot(srcRoot)
+	err := walkSource(srcRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(srcRoot, path)
+		if d.Name() == undoDirName || excluded(rel, true) || skipMount(path, rel) {
+			return filepath.SkipDir
+		}
+		dirs = append(dirs, rel)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rel := dirs[i]
+		info, err := os.Stat(filepath.Join(srcRoot, rel))
+		if err != nil {
+			continue
+		}
+		dst := filepath.Join(dstRoot, rel)
+		if dstInfo, err := os.Stat(dst); err != nil || !dstInfo.IsDir() {
+			continue
+		}
+		if err := preserveTimes(dst, info); err != nil {
+			if err := handleFailure(rel, err); err != nil {
+				return err
+			}
+		}
+		copyFileFlags(dst, info)
+		copyAttrs(filepath.Join(srcRoot, rel), dst)
+		copyWinAttrs(filepath.Join(srcRoot, rel), dst)
+	}
+	return nil
+}
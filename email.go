@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// smtpConfig holds the --smtp-* and --mail-* settings for emailed reports.
+type smtpConfig struct {
+	addr     string
+	user     string
+	password string
+	from     string
+	to       string
+}
+
+var mailConfig smtpConfig
+
+func (c smtpConfig) enabled() bool {
+	return c.addr != "" && c.to != ""
+}
+
+// recipients lists the addresses in --mail-to.
+func (c smtpConfig) recipients() []string {
+	var to []string
+	for _, r := range strings.Split(c.to, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			to = append(to, r)
+		}
+	}
+	return to
+}
+
+// validateMail checks the --smtp-host and --mail-to settings up front, so
+// a report that could never be sent fails the run before anything is
+// copied rather than at the end.
+func validateMail(c smtpConfig) error {
+	if c.addr == "" && c.to == "" {
+		return nil
+	}
+	if c.addr == "" || c.to == "" {
+		return errors.New("--smtp-host and --mail-to must be given together")
+	}
+	if _, _, err := net.SplitHostPort(c.addr); err != nil {
+		return fmt.Errorf("invalid --smtp-host %q: %v", c.addr, err)
+	}
+	if len(c.recipients()) == 0 {
+		return fmt.Errorf("invalid --mail-to %q: no recipients", c.to)
+	}
+	return nil
+}
+
+// sendReport emails a plain-text summary of a finished run.
+func sendReport(c smtpConfig, s statusSnapshot) error {
+	host, _, err := net.SplitHostPort(c.addr)
+	if err != nil {
+		return fmt.Errorf("invalid --smtp-host %q: %v", c.addr, err)
+	}
+	recipients := c.recipients()
+	from := c.from
+	if from == "" {
+		from = c.user
+	}
+
+	var auth smtp.Auth
+	if c.user != "" {
+		auth = smtp.PlainAuth("", c.user, c.password, host)
+	}
+
+	subject := fmt.Sprintf("lyphotos %s %s: %s -> %s", s.Operation, s.State, s.Source, s.Target)
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\n", summaryLine(s))
+	fmt.Fprintf(&msg, "Source:      %s\r\n", s.Source)
+	fmt.Fprintf(&msg, "Target:      %s\r\n", s.Target)
+	fmt.Fprintf(&msg, "Files:       %d transferred, %d skipped\r\n", s.Copied, s.Skipped)
+	fmt.Fprintf(&msg, "Bytes:       %s of %s\r\n", formatSize(s.BytesDone), formatSize(s.BytesTotal))
+	fmt.Fprintf(&msg, "Duration:    %s\r\n", time.Duration(s.Elapsed*float64(time.Second)).Round(time.Second))
+	if len(s.Failures) > 0 {
+		fmt.Fprintf(&msg, "\r\nFailures (%d):\r\n", len(s.Failures))
+		for _, f := range s.Failures {
+			fmt.Fprintf(&msg, "  %s: %s\r\n", f.Path, f.Error)
+		}
+	}
+
+	return sendMail(c.addr, host, auth, from, recipients, []byte(msg.String()))
+}
+
+// sendMail does what smtp.SendMail does, over a connection from dialTCP
+// so --proxy applies. Port 465 speaks TLS from the start (RFC 8314); other
+// ports upgrade with STARTTLS when the server offers it.
+func sendMail(addr, host string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	conn, err := dialTCP(addr)
+	if err != nil {
+		return err
+	}
+	_, port, _ := net.SplitHostPort(addr)
+	implicitTLS := port == "465"
+	if implicitTLS {
+		conn = tls.Client(conn, tlsConfigFor(host))
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && !implicitTLS {
+		if err := c.StartTLS(tlsConfigFor(host)); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// runEstimate compares source and target like a copy/move would and
+// reports what it would transfer and delete, and where the bulk of it is,
+// without changing either tree.
+func runEstimate(args []string) {
+	fs := flag.NewFlagSet("estimate", flag.ExitOnError)
+	del := fs.Bool("delete", false, "also count target files a --delete run would remove")
+	top := fs.Int("top", 10, "how many of the largest files and directories to list")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s estimate [--delete] [--top 10] <source> <target>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseSubcommand(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		exit(1)
+	}
+	srcRoot := filepath.Clean(fs.Arg(0))
+	dstRoot := filepath.Clean(fs.Arg(1))
+	for _, dir := range []string{srcRoot, dstRoot} {
+		if _, err := os.Stat(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+	}
+
+	// Only the report goes to stdout, not a line per file
+	quietOps = true
+	var ops []transferOp
+	var dirs int
+	err := walkTransfers(srcRoot, dstRoot, func(rel, dst string) error {
+		if rel != "." {
+			dirs++
+		}
+		return nil
+	}, func(op transferOp) error {
+		ops = append(ops, op)
+		return nil
+	})
+	var delFiles int
+	var delBytes int64
+	if err == nil && *del {
+		delFiles, delBytes, err = countExtraneous(srcRoot, dstRoot)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+
+	var total int64
+	var updates int
+	byDir := map[string]int64{}
+	for _, op := range ops {
+		total += op.size
+		if op.replace || op.offset > 0 {
+			updates++
+		}
+		dir, _, nested := strings.Cut(filepath.ToSlash(op.rel), "/")
+		if !nested {
+			dir = "."
+		}
+		byDir[dir] += op.size
+	}
+
+	fmt.Printf("To transfer: %d files, %s (%d new, %d updated)\n", len(ops), formatSize(total), len(ops)-updates, updates)
+	fmt.Printf("To create:   %d directories\n", dirs)
+	if *del {
+		fmt.Printf("To delete:   %d files, %s\n", delFiles, formatSize(delBytes))
+	}
+	fmt.Printf("Up to date:  %d files\n", skipped)
+	if failed > 0 {
+		fmt.Printf("Unreadable:  %d entries\n", failed)
+	}
+	if len(ops) == 0 || *top <= 0 {
+		return
+	}
+
+	slices.SortFunc(ops, func(a, b transferOp) int { return cmp.Compare(b.size, a.size) })
+	fmt.Printf("\nLargest files:\n")
+	for _, op := range ops[:min(*top, len(ops))] {
+		fmt.Printf("  %10s  %s\n", formatSize(op.size), op.rel)
+	}
+	names := slices.Collect(maps.Keys(byDir))
+	slices.SortFunc(names, func(a, b string) int { return cmp.Or(cmp.Compare(byDir[b], byDir[a]), strings.Compare(a, b)) })
+	fmt.Printf("\nLargest top-level directories:\n")
+	for _, name := range names[:min(*top, len(names))] {
+		fmt.Printf("  %10s  %4.1f%%  %s\n", formatSize(byDir[name]), pctOf(byDir[name], total), filepath.FromSlash(name))
+	}
+}
+
+// pctOf is n as a percentage of total.
+func pctOf(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+// countExtraneous counts the target files, and their bytes, that a
+// --delete run would remove.
+func countExtraneous(srcRoot, dstRoot string) (files int, bytes int64, err error) {
+	err = walkExtraneous(srcRoot, dstRoot, func(rel, path string, d fs.DirEntry) {
+		n, size := treeSize(path)
+		files += n
+		bytes += size
+	})
+	return files, bytes, err
+}
+
+// walkExtraneous calls fn for each target entry a --delete run would
+// remove, whole directories at a time, without removing anything.
+func walkExtraneous(srcRoot, dstRoot string, fn func(rel, path string, d fs.DirEntry)) error {
+	filters.useRoot(srcRoot)
+	return filepath.WalkDir(dstRoot, func(path string, d fs.DirEntry, err error) error {
+		rel, _ := filepath.Rel(dstRoot, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		remove, descend, err := extraneous(srcRoot, rel, d)
+		if err != nil {
+			return err
+		}
+		if remove {
+			fn(rel, path, d)
+		}
+		if remove || !descend {
+			return skipEntry(d)
+		}
+		return nil
+	})
+}
+
+// treeSize counts the files under path, or path itself, and their size.
+func treeSize(path string) (files int, bytes int64) {
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files++
+			if info, err := d.Info(); err == nil {
+				bytes += info.Size()
+			}
+		}
+		return nil
+	})
+	return files, bytes
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32                  = syscall.NewLazyDLL("advapi32.dll")
+	procRegisterEventSource   = advapi32.NewProc("RegisterEventSourceW")
+	procReportEvent           = advapi32.NewProc("ReportEventW")
+	procDeregisterEventSource = advapi32.NewProc("DeregisterEventSource")
+)
+
+const (
+	eventlogErrorType       = 0x0001
+	eventlogInformationType = 0x0004
+)
+
+// eventLogSink reports run summaries and errors to the Application event
+// log under the "lyphotos" source; per-file operation lines are left out.
+// Without a registered message file Event Viewer prefixes each entry with
+// a note that the description is missing, but the text is shown in full.
+type eventLogSink struct {
+	handle uintptr
+}
+
+func openEventLog() (logSink, error) {
+	source, err := syscall.UTF16PtrFromString("lyphotos")
+	if err != nil {
+		return nil, err
+	}
+	h, _, err := procRegisterEventSource.Call(0, uintptr(unsafe.Pointer(source)))
+	if h == 0 {
+		return nil, fmt.Errorf("cannot register event source: %w", err)
+	}
+	return eventLogSink{h}, nil
+}
+
+func (e eventLogSink) printf(format string, args ...any) {
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	if strings.HasPrefix(msg, "[") && !isErrorLine(msg) {
+		return
+	}
+	kind := eventlogInformationType
+	if isErrorLine(msg) {
+		kind = eventlogErrorType
+	}
+	text, err := syscall.UTF16PtrFromString(msg)
+	if err != nil {
+		return
+	}
+	strs := []*uint16{text}
+	procReportEvent.Call(e.handle, uintptr(kind), 0, 1, 0, 1, 0, uintptr(unsafe.Pointer(&strs[0])), 0)
+}
+
+func (e eventLogSink) close() { procDeregisterEventSource.Call(e.handle) }
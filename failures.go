@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+)
+
+var (
+	// failFastFlag stops the run at the first error instead of carrying on.
+	failFastFlag bool
+	// failed counts files and directories that could not be transferred.
+	failed int64
+)
+
+// handleFailure decides whether err ends the run. With --fail-fast, or
+// for an abort, it is passed through. Otherwise the failure is reported
+// and recorded for the summary and the run goes on with the next entry.
+func handleFailure(rel string, err error) error {
+	if err == nil || failFastFlag || errors.Is(err, errAborted) {
+		return err
+	}
+	atomic.AddInt64(&failed, 1)
+	noteFailedPath(rel)
+	status.recordError(rel, err)
+	logOp(os.Stderr, "[ERROR] %s: %v\n", rel, err)
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"sync"
+)
+
+// fdLimiter caps how many files a run keeps open at once. Opens beyond the
+// limit queue until another file is closed instead of failing with EMFILE.
+type fdLimiter struct {
+	slots chan struct{}
+}
+
+// openFiles is the limiter used for every file the transfer opens.
+var openFiles = newFDLimiter(0)
+
+// newFDLimiter allows n open files, or a ulimit-derived default when n is 0.
+// A copy holds its source and destination open together, so at least two
+// slots are always granted.
+func newFDLimiter(n int) *fdLimiter {
+	if n == 0 {
+		n = defaultMaxOpenFiles()
+	}
+	if n < 2 {
+		n = 2
+	}
+	return &fdLimiter{slots: make(chan struct{}, n)}
+}
+
+// limitedFile releases its limiter slot when closed.
+type limitedFile struct {
+	*os.File
+	release func()
+}
+
+func (f *limitedFile) Close() error {
+	err := f.File.Close()
+	f.release()
+	return err
+}
+
+func (l *fdLimiter) openFile(name string, flag int, perm os.FileMode) (*limitedFile, error) {
+	l.slots <- struct{}{}
+	var once sync.Once
+	release := func() { once.Do(func() { <-l.slots }) }
+	fileOps.wait()
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &limitedFile{File: f, release: release}, nil
+}
+
+func (l *fdLimiter) open(name string) (*limitedFile, error) {
+	return openReadonly(l, name)
+}
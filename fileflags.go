@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// fileFlagsFlag copies BSD file flags (chflags(1): uchg, hidden, nodump and
+// so on) from source entries to their copies where the platform has them.
+var fileFlagsFlag bool
+
+// copyFileFlags gives dst the file flags of src. It runs once nothing more
+// is written to dst, since flags such as uchg would block that. A failure,
+// for example a system flag without root, is a warning only.
+func copyFileFlags(dst string, src fs.FileInfo) {
+	if !fileFlagsFlag {
+		return
+	}
+	flags, ok := fileFlags(src)
+	if !ok || flags == 0 {
+		return
+	}
+	if err := setFileFlags(dst, flags); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot set file flags on %s: %v\n", dst, err)
+	}
+}
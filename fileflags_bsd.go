@@ -0,0 +1,23 @@
+//go:build darwin || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+const fileFlagsSupported = true
+
+// fileFlags returns the st_flags of info.
+func fileFlags(info fs.FileInfo) (uint32, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return st.Flags, true
+}
+
+func setFileFlags(path string, flags uint32) error {
+	return syscall.Chflags(path, int(flags))
+}
@@ -0,0 +1,18 @@
+//go:build !darwin && !freebsd && !netbsd && !openbsd
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+)
+
+const fileFlagsSupported = false
+
+func fileFlags(info fs.FileInfo) (uint32, bool) {
+	return 0, false
+}
+
+func setFileFlags(path string, flags uint32) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// fileList restricts a run to the paths named in a --files-from list.
+// Listed directories are transferred with everything in them.
+type fileList struct {
+	paths   map[string]bool
+	parents map[string]bool
+}
+
+// filesFrom is set by --files-from or --retry-failed.
+var filesFrom *fileList
+
+// readFileList reads one path per line, relative to the source root.
+// Blank lines and lines starting with # are ignored.
+func readFileList(name string) (*fileList, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	l := &fileList{paths: make(map[string]bool), parents: make(map[string]bool)}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rel := filepath.Clean(filepath.FromSlash(strings.TrimPrefix(line, "/")))
+		l.paths[rel] = true
+		for dir := filepath.Dir(rel); dir != "."; dir = filepath.Dir(dir) {
+			l.parents[dir] = true
+		}
+	}
+	return l, sc.Err()
+}
+
+// allows reports whether rel is listed, inside a listed directory, or a
+// directory that has to be entered to reach one.
+func (l *fileList) allows(rel string, isDir bool) bool {
+	if isDir && l.parents[rel] {
+		return true
+	}
+	for p := rel; p != "."; p = filepath.Dir(p) {
+		if l.paths[p] {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	failedMu    sync.Mutex
+	failedPaths []string
+)
+
+func noteFailedPath(rel string) {
+	failedMu.Lock()
+	failedPaths = append(failedPaths, rel)
+	failedMu.Unlock()
+}
+
+// pairID names the state kept for runs from srcRoot to dstRoot, and
+// returns both as absolute paths.
+func pairID(srcRoot, dstRoot string) (src, dst, id string, err error) {
+	if src, err = filepath.Abs(srcRoot); err != nil {
+		return
+	}
+	if dst, err = filepath.Abs(dstRoot); err != nil {
+		return
+	}
+	sum := sha256.Sum256([]byte(src + "\x00" + dst))
+	return src, dst, hex.EncodeToString(sum[:8]), nil
+}
+
+// retryListPath is where the failures of a run from srcRoot to dstRoot
+// are written for --retry-failed.
+func retryListPath(srcRoot, dstRoot string) (string, error) {
+	_, _, id, err := pairID(srcRoot, dstRoot)
+	if err != nil {
+		return "", err
+	}
+	return userCacheFile("failed-" + id + ".txt")
+}
+
+// writeRetryList saves the paths that failed in this run, one per line,
+// and returns the file name.
+func writeRetryList(srcRoot, dstRoot string) (string, error) {
+	path, err := retryListPath(srcRoot, dstRoot)
+	if err != nil {
+		return "", err
+	}
+	failedMu.Lock()
+	paths := slices.Clone(failedPaths)
+	failedMu.Unlock()
+	slices.Sort(paths)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# lyphotos failures: %s -> %s\n", srcRoot, dstRoot)
+	for _, p := range slices.Compact(paths) {
+		b.WriteString(filepath.ToSlash(p) + "\n")
+	}
+	return path, os.WriteFile(path, []byte(b.String()), 0o644)
+}
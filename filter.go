@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// filterRule is one rsync-style include/exclude rule. Rules read from a
+// per-directory merge file match paths relative to that directory (base).
+type filterRule struct {
+	include  bool
+	dirOnly  bool
+	nameOnly bool // match the last path component only
+	clear    bool // "!": drop the rules so far
+	pattern  string
+	re       *regexp.Regexp
+	dirMerge string // name of a per-directory merge file, for ": FILE" rules
+	base     string
+}
+
+// filterSet is the ordered rule list built from --filter, --include and
+// --exclude. As in rsync the first matching rule decides, and a path no
+// rule matches is included.
+type filterSet struct {
+	rules []filterRule
+	root  string
+
+	// Per-directory merge files read so far (nil if absent), and the rule
+	// list expanded for the directory last asked about; walks visit a
+	// directory's entries together, so one expanded list is enough.
+	merged     map[string][]filterRule
+	cacheDir   string
+	cacheRules []filterRule
+}
+
+var filters filterSet
+
+// filterFlag adds rules to filters. --exclude and --include prefix the
+// pattern with the rule they stand for; --filter takes whole rules.
+type filterFlag struct {
+	prefix string
+}
+
+func (f filterFlag) String() string { return "" }
+
+func (filterFlag) repeatable() {}
+
+func (f filterFlag) Set(v string) error {
+	return filters.add(f.prefix+v, "")
+}
+
+// add parses a rule, reading merge files straight away.
+func (s *filterSet) add(line, base string) error {
+	rules, err := parseFilterRule(line, base)
+	if err != nil {
+		return err
+	}
+	for _, r := range rules {
+		if r.clear {
+			s.rules = nil
+			continue
+		}
+		s.rules = append(s.rules, r)
+	}
+	s.cacheDir, s.cacheRules = "", nil
+	return nil
+}
+
+// parseFilterRule parses one line of rsync filter syntax: "+ PATTERN",
+// "- PATTERN", "include"/"exclude", ". FILE" or "merge FILE" (read now),
+// ": FILE" or "dir-merge FILE" (read from each directory during the walk)
+// and "!" or "clear". Blank lines and lines starting with # or ; are ignored.
+func parseFilterRule(line, base string) ([]filterRule, error) {
+	line = strings.TrimRight(line, "\r")
+	if strings.TrimSpace(line) == "" || line[0] == '#' || line[0] == ';' {
+		return nil, nil
+	}
+	kind, arg, ok := strings.Cut(line, " ")
+	if !ok {
+		kind, arg, ok = strings.Cut(line, "_")
+	}
+	switch kind {
+	case "!", "clear":
+		return []filterRule{{clear: true}}, nil
+	}
+	if !ok || arg == "" {
+		return nil, fmt.Errorf("invalid filter rule %q", line)
+	}
+	switch kind {
+	case "+", "include", "-", "exclude":
+		r, err := compileFilterPattern(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter rule %q: %v", line, err)
+		}
+		r.include = kind == "+" || kind == "include"
+		r.base = base
+		return []filterRule{r}, nil
+	case ".", "merge":
+		return readFilterFile(arg, base)
+	case ":", "dir-merge":
+		if strings.Contains(arg, "/") {
+			return nil, fmt.Errorf("invalid filter rule %q: dir-merge takes a plain file name", line)
+		}
+		return []filterRule{{dirMerge: arg}}, nil
+	}
+	return nil, fmt.Errorf("unsupported filter rule %q", line)
+}
+
+func readFilterFile(name, base string) ([]filterRule, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rules []filterRule
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		r, err := parseFilterRule(sc.Text(), base)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, n, err)
+		}
+		rules = append(rules, r...)
+	}
+	return rules, sc.Err()
+}
+
+// compileFilterPattern turns an rsync pattern into a regular expression
+// over slash-separated paths. A leading slash anchors it at the transfer
+// root; a pattern containing a slash or ** otherwise matches any trailing
+// run of whole path components, and one without matches the name alone.
+// * and ? stop at slashes, ** does not, and a final dir/*** matches dir
+// and everything in it. A trailing slash limits the rule to directories.
+func compileFilterPattern(pattern string) (filterRule, error) {
+	r := filterRule{pattern: pattern}
+	if strings.HasSuffix(pattern, "/") && pattern != "/" {
+		r.dirOnly = true
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	anchored := strings.HasPrefix(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	wholePath := anchored || strings.Contains(pattern, "/") || strings.Contains(pattern, "**")
+
+	var re strings.Builder
+	switch {
+	case anchored || !wholePath:
+		re.WriteString("^")
+	default:
+		re.WriteString("(^|/)")
+	}
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "/***") && i+4 == len(pattern):
+			re.WriteString("(/.*)?")
+			i += 3
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return r, fmt.Errorf("unterminated [ in %q", r.pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return r, err
+	}
+	r.re = compiled
+	r.nameOnly = !wholePath
+	return r, nil
+}
+
+func (r filterRule) matches(rel string, isDir bool) bool {
+	if r.re == nil || r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		rel = strings.TrimPrefix(rel, r.base+"/")
+	}
+	if r.nameOnly {
+		return r.re.MatchString(path.Base(rel))
+	}
+	return r.re.MatchString(rel)
+}
+
+// useRoot points per-directory merge files at a new source tree.
+func (s *filterSet) useRoot(root string) {
+	if s.root != root {
+		s.root, s.merged, s.cacheDir, s.cacheRules = root, nil, "", nil
+	}
+}
+
+// rulesFor expands dir-merge rules for entries in dir. Rules from deeper
+// directories come first, so they take precedence over inherited ones.
+func (s *filterSet) rulesFor(dir string) []filterRule {
+	if s.cacheRules != nil && s.cacheDir == dir {
+		return s.cacheRules
+	}
+	var out []filterRule
+	for _, r := range s.rules {
+		if r.dirMerge == "" {
+			out = append(out, r)
+			continue
+		}
+		for d := dir; ; d = path.Dir(d) {
+			base := d
+			if base == "." {
+				base = ""
+			}
+			out = append(out, s.mergeFile(base, r.dirMerge)...)
+			if d == "." {
+				break
+			}
+		}
+	}
+	s.cacheDir, s.cacheRules = dir, out
+	return out
+}
+
+func (s *filterSet) mergeFile(dir, name string) []filterRule {
+	file := filepath.Join(s.root, filepath.FromSlash(dir), name)
+	if rules, ok := s.merged[file]; ok {
+		return rules
+	}
+	rules, err := readFilterFile(file, dir)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring %v\n", err)
+	}
+	if s.merged == nil {
+		s.merged = make(map[string][]filterRule)
+	}
+	s.merged[file] = rules
+	return rules
+}
+
+// excluded reports whether the entry at rel (relative to the source root)
+// is excluded by the filter rules or missing from --files-from. The root
+// itself never is.
+func excluded(rel string, isDir bool) bool {
+	if rel == "." {
+		return false
+	}
+	if filesFrom != nil && !filesFrom.allows(rel, isDir) {
+		return true
+	}
+	if len(filters.rules) == 0 {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, r := range filters.rulesFor(path.Dir(rel)) {
+		if r.matches(rel, isDir) {
+			return !r.include
+		}
+	}
+	return false
+}
+
+// excludedPath is excluded for an entry that may sit inside an excluded
+// directory, for callers that can't prune the walk.
+func excludedPath(rel string, isDir bool) bool {
+	if excluded(rel, isDir) {
+		return true
+	}
+	for dir := filepath.Dir(rel); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if excluded(dir, true) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// minFreeFlag keeps a reserve of free space on the target: a run stops
+// before a file would take the free space below it.
+var (
+	minFreeFlag string
+	minFree     int64
+)
+
+func parseMinFree() error {
+	if minFreeFlag == "" {
+		return nil
+	}
+	n, err := parseSize(minFreeFlag)
+	if err != nil {
+		return fmt.Errorf("invalid --min-free %q", minFreeFlag)
+	}
+	minFree = n
+	return nil
+}
+
+// checkFreeSpace fails if writing op would leave less than --min-free on
+// the target file system.
+func checkFreeSpace(op transferOp) error {
+	if minFree == 0 {
+		return nil
+	}
+	// The target directory may not have been created yet
+	dir := filepath.Dir(op.dst)
+	for !exists(dir) && filepath.Dir(dir) != dir {
+		dir = filepath.Dir(dir)
+	}
+	free, err := freeSpace(dir)
+	if err != nil {
+		return fmt.Errorf("--min-free: %w", err)
+	}
+	if free-op.size < minFree {
+		return fmt.Errorf("stopping before %s: %s free on the target, --min-free keeps %s", op.rel, formatSize(free), formatSize(minFree))
+	}
+	return nil
+}
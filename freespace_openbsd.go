@@ -0,0 +1,13 @@
+package main
+
+import "syscall"
+
+// freeSpace returns the bytes available to unprivileged users on the file
+// system holding path.
+func freeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.F_bavail * int64(st.F_bsize), nil
+}
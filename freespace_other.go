@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !openbsd && !windows
+
+package main
+
+import "errors"
+
+func freeSpace(path string) (int64, error) {
+	return 0, errors.New("free space is not available on this platform")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// --only-when-idle holds transfers while other processes keep the CPU
+// busy (or, where it can be detected, while someone is using the
+// desktop) and lets them continue once the machine has been idle for a
+// while. The run's own CPU time is not counted.
+var (
+	onlyWhenIdleFlag bool
+	idleThreshold    int
+)
+
+const (
+	idleSampleInterval = 5 * time.Second
+	idleResumeAfter    = 30 * time.Second
+	// userIdleAfter is how long without keyboard or mouse input counts
+	// as nobody at the desktop.
+	userIdleAfter = 2 * time.Minute
+)
+
+const holdBusy = "system busy"
+
+// startIdleMonitor samples the system load in the background for as long
+// as the run lasts.
+func startIdleMonitor() error {
+	prev, err := sampleLoad()
+	if err != nil {
+		return fmt.Errorf("--only-when-idle: %w", err)
+	}
+	go func() {
+		held := false
+		var idleSince time.Time
+		for range time.Tick(idleSampleInterval) {
+			cur, err := sampleLoad()
+			if err != nil {
+				continue
+			}
+			busy := cur.othersPercent(prev) > float64(idleThreshold)
+			if input, ok := userInputIdle(); ok && input < userIdleAfter {
+				busy = true
+			}
+			prev = cur
+			switch {
+			case busy:
+				idleSince = time.Time{}
+				if !held {
+					held = true
+					control.hold(holdBusy, true)
+					logOp(os.Stderr, "[IDLE] System busy, waiting\n")
+				}
+			case held && idleSince.IsZero():
+				idleSince = time.Now()
+			case held && time.Since(idleSince) >= idleResumeAfter:
+				held = false
+				control.hold(holdBusy, false)
+				logOp(os.Stderr, "[IDLE] System idle, resuming\n")
+			}
+		}
+	}()
+	return nil
+}
+
+// loadSample is a snapshot of cumulative CPU time: busy and total across
+// all CPUs, and the part of busy used by this process.
+type loadSample struct {
+	busy, total, own time.Duration
+}
+
+// othersPercent is the share of CPU time other processes used between
+// prev and s.
+func (s loadSample) othersPercent(prev loadSample) float64 {
+	total := s.total - prev.total
+	if total <= 0 {
+		return 0
+	}
+	others := (s.busy - prev.busy) - (s.own - prev.own)
+	if others < 0 {
+		others = 0
+	}
+	return float64(others) * 100 / float64(total)
+}
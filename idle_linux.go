@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// clockTick is the unit of /proc/stat; USER_HZ is 100 on every Linux
+// architecture Go supports.
+const clockTick = 10 * time.Millisecond
+
+// sampleLoad reads the aggregate cpu line of /proc/stat and this
+// process's CPU time.
+func sampleLoad() (loadSample, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return loadSample{}, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return loadSample{}, errors.New("cannot read /proc/stat")
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return loadSample{}, errors.New("unexpected /proc/stat format")
+	}
+	var s loadSample
+	for i, field := range fields[1:] {
+		// guest time is already included in user and nice
+		if i >= 8 {
+			break
+		}
+		ticks, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return loadSample{}, err
+		}
+		d := time.Duration(ticks) * clockTick
+		s.total += d
+		// idle and iowait
+		if i != 3 && i != 4 {
+			s.busy += d
+		}
+	}
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return loadSample{}, err
+	}
+	s.own = time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+	return s, nil
+}
+
+// userInputIdle is not available without a display server connection.
+func userInputIdle() (time.Duration, bool) {
+	return 0, false
+}
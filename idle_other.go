@@ -0,0 +1,16 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+func sampleLoad() (loadSample, error) {
+	return loadSample{}, errors.New("load monitoring is not supported on this platform")
+}
+
+func userInputIdle() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	procGetSystemTimes   = syscall.NewLazyDLL("kernel32.dll").NewProc("GetSystemTimes")
+	procGetLastInputInfo = syscall.NewLazyDLL("user32.dll").NewProc("GetLastInputInfo")
+	procGetTickCount     = syscall.NewLazyDLL("kernel32.dll").NewProc("GetTickCount")
+)
+
+// filetimeDuration converts a FILETIME interval (100ns units).
+func filetimeDuration(ft syscall.Filetime) time.Duration {
+	return time.Duration(int64(ft.HighDateTime)<<32|int64(ft.LowDateTime)) * 100
+}
+
+// sampleLoad uses GetSystemTimes, whose kernel time includes idle time,
+// and GetProcessTimes for this process.
+func sampleLoad() (loadSample, error) {
+	var idle, kernel, user syscall.Filetime
+	if r, _, err := procGetSystemTimes.Call(uintptr(unsafe.Pointer(&idle)), uintptr(unsafe.Pointer(&kernel)), uintptr(unsafe.Pointer(&user))); r == 0 {
+		return loadSample{}, err
+	}
+	var s loadSample
+	s.total = filetimeDuration(kernel) + filetimeDuration(user)
+	s.busy = s.total - filetimeDuration(idle)
+
+	var created, exited, ownKernel, ownUser syscall.Filetime
+	self, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return loadSample{}, err
+	}
+	if err := syscall.GetProcessTimes(self, &created, &exited, &ownKernel, &ownUser); err != nil {
+		return loadSample{}, err
+	}
+	s.own = filetimeDuration(ownKernel) + filetimeDuration(ownUser)
+	return s, nil
+}
+
+// userInputIdle reports how long ago the last keyboard or mouse input of
+// the interactive session was.
+func userInputIdle() (time.Duration, bool) {
+	info := struct {
+		size uint32
+		time uint32
+	}{size: 8}
+	if r, _, _ := procGetLastInputInfo.Call(uintptr(unsafe.Pointer(&info))); r == 0 {
+		return 0, false
+	}
+	now, _, _ := procGetTickCount.Call()
+	return time.Duration(uint32(now)-info.time) * time.Millisecond, true
+}
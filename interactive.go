@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// changeSet is everything a copy/move would do, collected up front so it
+// can be reviewed before anything is written.
+type changeSet struct {
+	dirs      []transferOp
+	files     []transferOp
+	updates   []transferOp
+	appends   []transferOp
+	deletions []transferOp
+}
+
+// changeGroup is one reviewable part of a changeSet.
+type changeGroup struct {
+	title string
+	ops   *[]transferOp
+}
+
+// interactiveListLimit caps how many entries of a group are printed.
+const interactiveListLimit = 50
+
+func collectChanges(srcRoot, dstRoot string) (*changeSet, error) {
+	// Nothing may be deleted before the set is confirmed, so deletions
+	// "during" the walk come after the transfers, as with --delete-after
+	if deleteTiming == deleteDuring {
+		deleteTiming = deleteAfter
+	}
+	changes := &changeSet{}
+	err := walkTransfers(srcRoot, dstRoot, func(rel, dst string) error {
+		if !pruneEmptyDirsFlag {
+			changes.dirs = append(changes.dirs, transferOp{dst: dst, rel: rel})
+		}
+		return nil
+	}, func(op transferOp) error {
+		switch {
+		case op.offset > 0:
+			changes.appends = append(changes.appends, op)
+		case op.replace:
+			changes.updates = append(changes.updates, op)
+		default:
+			changes.files = append(changes.files, op)
+		}
+		return nil
+	})
+	if err == nil && deleteFlag {
+		err = walkExtraneous(srcRoot, dstRoot, func(rel, path string, d fs.DirEntry) {
+			_, size := treeSize(path)
+			changes.deletions = append(changes.deletions, transferOp{dst: path, rel: rel, size: size})
+		})
+	}
+	sortTransfers(changes.files, orderFlag)
+	sortTransfers(changes.updates, orderFlag)
+	sortTransfers(changes.appends, orderFlag)
+	return changes, err
+}
+
+// confirmChanges prints the change set grouped by kind and asks whether
+// to go ahead with all of it, none of it, or group by group. Declined
+// groups are dropped from the set. It reports false if nothing is left.
+func confirmChanges(changes *changeSet, operation string, in io.Reader) bool {
+	groups := []changeGroup{
+		{title: "Directories to create", ops: &changes.dirs},
+		{title: "Files to " + operation, ops: &changes.files},
+		{title: "Files to update", ops: &changes.updates},
+		{title: "Files to append to", ops: &changes.appends},
+		{title: "Entries to delete", ops: &changes.deletions},
+	}
+
+	fmt.Println()
+	for _, g := range groups {
+		if len(*g.ops) == 0 {
+			continue
+		}
+		var size int64
+		for _, op := range *g.ops {
+			size += op.size
+		}
+		fmt.Printf("%s (%d, %s):\n", g.title, len(*g.ops), formatSize(size))
+		for i, op := range *g.ops {
+			if i == interactiveListLimit {
+				fmt.Printf("  ... and %d more\n", len(*g.ops)-i)
+				break
+			}
+			fmt.Printf("  %s\n", op.rel)
+		}
+	}
+	if changes.empty() {
+		fmt.Println("Nothing to do.")
+		return false
+	}
+
+	reader := bufio.NewReader(in)
+	switch ask(reader, "\nProceed? [y]es, [n]o, [g]roup by group: ") {
+	case "y", "yes":
+		return true
+	case "g", "group":
+		for _, g := range groups {
+			if len(*g.ops) == 0 {
+				continue
+			}
+			answer := ask(reader, fmt.Sprintf("%s (%d)? [y/N]: ", g.title, len(*g.ops)))
+			if answer != "y" && answer != "yes" {
+				*g.ops = nil
+			}
+		}
+		return !changes.empty()
+	default:
+		return false
+	}
+}
+
+func ask(reader *bufio.Reader, prompt string) string {
+	fmt.Fprint(os.Stderr, prompt)
+	line, _ := reader.ReadString('\n')
+	return strings.ToLower(strings.TrimSpace(line))
+}
+
+func (c *changeSet) empty() bool {
+	return len(c.dirs) == 0 && len(c.files) == 0 && len(c.updates) == 0 && len(c.appends) == 0 && len(c.deletions) == 0
+}
+
+// apply performs the confirmed changes. Deletions come first with
+// --delete-before, and otherwise last, only once every transfer succeeded.
+func (c *changeSet) apply() error {
+	if deleteTiming == deleteBefore {
+		if err := c.delete(); err != nil {
+			return err
+		}
+	}
+	for _, d := range c.dirs {
+		if err := makeDir(d.dst); err != nil {
+			return err
+		}
+	}
+	for _, group := range [][]transferOp{c.files, c.updates, c.appends} {
+		for _, op := range group {
+			if err := runTransfer(op); err != nil {
+				return err
+			}
+		}
+	}
+	if deleteTiming == deleteBefore || len(c.deletions) == 0 {
+		return nil
+	}
+	// Deleting after a partial copy could lose the only good version
+	if n := atomic.LoadInt64(&failed); n > 0 {
+		logOp(os.Stdout, "Deletions skipped: %d transfers failed\n", n)
+		return nil
+	}
+	return c.delete()
+}
+
+// delete removes the confirmed deletions that are still there.
+func (c *changeSet) delete() error {
+	for _, op := range c.deletions {
+		info, err := os.Lstat(op.dst)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			if err := handleFailure(op.rel, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := deleteEntry(op.dst, op.rel, info.IsDir()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
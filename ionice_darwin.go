@@ -0,0 +1,15 @@
+//go:build darwin
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// lowerIOPriority puts the process into the background task policy, which
+// throttles its disk I/O (and CPU) below interactive applications.
+func lowerIOPriority() error {
+	return exec.Command("taskpolicy", "-b", "-p", strconv.Itoa(os.Getpid())).Run()
+}
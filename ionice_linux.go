@@ -0,0 +1,43 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+const (
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+	ioprioWhoProcess = 1
+)
+
+// lowerIOPriority moves every thread of the process into the idle I/O
+// scheduling class. ioprio is per thread on Linux, and threads started
+// later inherit it from their creator.
+func lowerIOPriority() error {
+	prio := uintptr(ioprioClassIdle << ioprioClassShift)
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return setThreadIOPriority(0, prio)
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := setThreadIOPriority(tid, prio); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setThreadIOPriority(tid int, prio uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), prio); errno != 0 {
+		return errno
+	}
+	return nil
+}
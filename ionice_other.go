@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "errors"
+
+func lowerIOPriority() error {
+	return errors.New("I/O priority control is not supported on this platform")
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+const processModeBackgroundBegin = 0x00100000
+
+// lowerIOPriority enters background processing mode, which lowers both
+// the I/O and memory priority of the process.
+func lowerIOPriority() error {
+	setPriorityClass := syscall.NewLazyDLL("kernel32.dll").NewProc("SetPriorityClass")
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return err
+	}
+	if ok, _, err := setPriorityClass.Call(uintptr(process), processModeBackgroundBegin); ok == 0 {
+		return err
+	}
+	return nil
+}
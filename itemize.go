@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// itemizeFlag replaces the [COPY]/[MOVE]/[APPEND]/[DELETE] tags with
+// rsync-style change codes explaining why each entry is touched.
+var itemizeFlag bool
+
+// Change codes for entries that are created or deleted outright. The
+// eleven columns are update type, file type, then the c s t p o g u a x
+// attribute flags; see rsync(1) --itemize-changes.
+const (
+	itemNewFile = ">f+++++++++"
+	itemNewDir  = "cd+++++++++"
+	itemDeleted = "*deleting  "
+)
+
+// modifyWindow is how far apart two modification times may be and still
+// count as equal (--modify-window), for targets such as FAT that store
+// them at a coarser granularity.
+var modifyWindow time.Duration
+
+// changedFile reports whether an existing target file is out of date:
+// different in size or modification time.
+func changedFile(src, dst fs.FileInfo) bool {
+	return src.Size() != dst.Size() || !sameModTime(src.ModTime(), dst.ModTime())
+}
+
+// sameModTime compares modification times at --time-precision, within
+// --modify-window.
+func sameModTime(a, b time.Time) bool {
+	d := atPrecision(a).Sub(atPrecision(b))
+	return d <= modifyWindow && d >= -modifyWindow
+}
+
+// changeCode describes how an existing target entry dst differs from src:
+// s for size, t for modification time and p for permissions.
+func changeCode(src, dst fs.FileInfo) string {
+	code := []byte(">f.........")
+	if src.IsDir() {
+		code[0], code[1] = '.', 'd'
+	}
+	if src.Size() != dst.Size() {
+		code[3] = 's'
+	}
+	if !sameModTime(src.ModTime(), dst.ModTime()) {
+		code[4] = 't'
+	}
+	if src.Mode().Perm() != dst.Mode().Perm() {
+		code[5] = 'p'
+	}
+	return string(code)
+}
+
+// logTransfer reports the file op is about to transfer.
+func logTransfer(w io.Writer, op transferOp) {
+	if itemizeFlag {
+		code := itemNewFile
+		if op.offset > 0 || op.replace {
+			src, err1 := os.Stat(op.src)
+			dst, err2 := os.Stat(op.dst)
+			if err1 == nil && err2 == nil {
+				code = changeCode(src, dst)
+			}
+		}
+		logOp(w, "%s %s\n", code, op.rel)
+		return
+	}
+
+	switch {
+	case op.offset > 0:
+		logOp(w, "[APPEND] %s (+%s)\n", op.rel, formatSize(op.size))
+	case op.replace:
+		logOp(w, "[UPDATE] %s\n", op.rel)
+	case moveFlag:
+		logOp(w, "[MOVE] %s\n", op.rel)
+	default:
+		logOp(w, "[COPY] %s\n", op.rel)
+	}
+}
+
+// logNewDir reports a directory created on the target. Only --itemize
+// lists them.
+func logNewDir(rel string) {
+	if itemizeFlag {
+		logOp(os.Stdout, "%s %s%c\n", itemNewDir, rel, filepath.Separator)
+	}
+}
+
+// logDelete reports a target entry being deleted; name carries a trailing
+// separator for directories.
+func logDelete(name string) {
+	if itemizeFlag {
+		logOp(os.Stdout, "%s %s\n", itemDeleted, name)
+		return
+	}
+	logOp(os.Stdout, "[DELETE] %s\n", name)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const journaldSocket = "/run/systemd/journal/socket"
+
+// journaldSink writes the run log straight to the systemd journal using
+// its native datagram protocol, so entries carry a priority and the
+// lyphotos identifier without going through syslog.
+type journaldSink struct {
+	conn net.Conn
+}
+
+func openJournald() (logSink, error) {
+	conn, err := net.Dial("unixgram", journaldSocket)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to journald: %w", err)
+	}
+	return journaldSink{conn}, nil
+}
+
+func (j journaldSink) printf(format string, args ...any) {
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	priority := 6 // info
+	if isErrorLine(msg) {
+		priority = 3 // err
+	}
+	// Newlines would end the field in the simple KEY=value format
+	msg = strings.ReplaceAll(msg, "\n", " ")
+	fmt.Fprintf(j.conn, "MESSAGE=%s\nPRIORITY=%d\nSYSLOG_IDENTIFIER=lyphotos\n", msg, priority)
+}
+
+func (j journaldSink) close() { j.conn.Close() }
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func openJournald() (logSink, error) {
+	return nil, errors.New("journald is only available on Linux")
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// excludeDirLargerThan leaves out source directories holding more
+	// than this many bytes in all (--exclude-dir-larger-than); 0 is off.
+	excludeDirLargerThanFlag string
+	excludeDirLargerThan     int64
+
+	// dirSizes holds the total size of every source directory, collected
+	// by scanSource. It is nil until a scan has filled it, and sizes are
+	// otherwise worked out when a directory is reached.
+	dirSizes map[string]int64
+	// smallDirs are directories known to be under the limit, so nothing
+	// below them has to be measured.
+	smallDirs = map[string]bool{}
+)
+
+func parseExcludeDirLargerThan() error {
+	if excludeDirLargerThanFlag == "" {
+		return nil
+	}
+	n, err := parseSize(excludeDirLargerThanFlag)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("invalid --exclude-dir-larger-than %q", excludeDirLargerThanFlag)
+	}
+	excludeDirLargerThan = n
+	return nil
+}
+
+// noteScannedFile adds a file found by the scan to the size of each
+// directory above it.
+func noteScannedFile(rel string, size int64) {
+	if dirSizes == nil {
+		return
+	}
+	for dir := filepath.Dir(rel); dir != "."; dir = filepath.Dir(dir) {
+		dirSizes[dir] += size
+	}
+}
+
+// dirTooLarge reports whether the source directory path, rel in the
+// source, is over --exclude-dir-larger-than, and its size if so. The
+// source root itself is never left out.
+func dirTooLarge(path, rel string) (int64, bool) {
+	if excludeDirLargerThan == 0 || smallDirs[filepath.Dir(rel)] && rel != "." {
+		return 0, false
+	}
+	var size int64
+	if dirSizes != nil && rel != "." {
+		size = dirSizes[rel]
+	} else {
+		size = measureDir(path, rel)
+	}
+	if size <= excludeDirLargerThan {
+		smallDirs[rel] = true
+		return 0, false
+	}
+	return size, rel != "."
+}
+
+// measureDir adds up the files under the source directory path, rel in
+// the source, leaving out what the scan leaves out: symlinks, excluded
+// entries, mounts skipped by --mounts and the undo journal.
+func measureDir(path, rel string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		sub, _ := filepath.Rel(path, p)
+		sub = filepath.Join(rel, sub)
+		if d.IsDir() {
+			if p != path && (d.Name() == undoDirName || excluded(sub, true) || skipMount(p, sub)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type()&os.ModeSymlink != 0 || excluded(sub, false) {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
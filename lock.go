@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// lockFileName is the run lock kept in the target's undo directory, which
+// every walk already leaves alone. The file stays after a run: removing it
+// would let a waiting run and a new one lock different files.
+const lockFileName = "lock"
+
+// lockWait is how long a run waits for another one writing to the same
+// target (--lock-wait) before giving up; 0 gives up at once.
+var lockWait time.Duration
+
+// errLocked is returned by tryLock when another process holds the lock.
+var errLocked = errors.New("locked by another process")
+
+// lockTarget makes sure no other run writes to dstRoot at the same time,
+// waiting up to --lock-wait for one that does. The lock is held until the
+// process exits.
+func lockTarget(dstRoot string) {
+	dir := filepath.Join(dstRoot, undoDirName)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot lock %s: %v\n", dstRoot, err)
+		return
+	}
+	f, err := os.OpenFile(filepath.Join(dir, lockFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot lock %s: %v\n", dstRoot, err)
+		return
+	}
+	deadline := time.Now().Add(lockWait)
+	waiting := false
+	for {
+		err := tryLock(f)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errLocked) {
+			fmt.Fprintf(os.Stderr, "Warning: cannot lock %s: %v\n", dstRoot, err)
+			f.Close()
+			return
+		}
+		if !time.Now().Before(deadline) {
+			fmt.Fprintf(os.Stderr, "Error: another run (%s) is writing to %s; use --lock-wait to wait for it\n", lockHolder(f), dstRoot)
+			exit(1)
+		}
+		if !waiting {
+			fmt.Fprintf(os.Stderr, "Waiting for another run (%s) writing to %s\n", lockHolder(f), dstRoot)
+			waiting = true
+		}
+		time.Sleep(min(time.Second, time.Until(deadline)))
+	}
+
+	host, _ := os.Hostname()
+	f.Truncate(0)
+	f.WriteAt(fmt.Appendf(nil, "pid %d on %s since %s\n", os.Getpid(), host, time.Now().Format(time.DateTime)), 0)
+	exitHooks = append(exitHooks, func() {
+		f.Truncate(0)
+		f.Close()
+	})
+}
+
+// lockHolder describes the process holding the lock, as it recorded
+// itself in the lock file.
+func lockHolder(f *os.File) string {
+	data, _ := io.ReadAll(io.NewSectionReader(f, 0, 256))
+	if holder := strings.TrimSpace(string(data)); holder != "" {
+		return holder
+	}
+	return "unknown process"
+}
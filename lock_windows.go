@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+// tryLock locks a byte of f far past its contents, so other processes can
+// still read who holds it.
+func tryLock(f *os.File) error {
+	ol := syscall.Overlapped{OffsetHigh: 1}
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r != 0 {
+		return nil
+	}
+	if err == errorLockViolation {
+		return errLocked
+	}
+	return err
+}
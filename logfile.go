@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatingLog appends timestamped lines to a log file (--log-file). Once
+// the file grows past maxSize or is older than maxAge it is renamed to
+// <path>.<timestamp> and a new one is started; only the newest keep
+// rotated files are retained, so a long-lived sync can't fill the disk
+// with its own logs.
+type rotatingLog struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	keep    int
+	file    *os.File
+	size    int64
+	opened  time.Time
+}
+
+// runLog is non-nil when --log-file or --log-target is set.
+var runLog logSink
+
+func openRotatingLog(path string, maxSize int64, maxAge time.Duration, keep int) (*rotatingLog, error) {
+	l := &rotatingLog{path: path, maxSize: maxSize, maxAge: maxAge, keep: keep}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *rotatingLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size, l.opened = f, info.Size(), time.Now()
+	if started, ok := logStarted(l.path); ok {
+		// A log continued from earlier runs ages from its first line
+		l.opened = started
+	}
+	return nil
+}
+
+// logStarted reads the timestamp that begins the first line of a log.
+func logStarted(path string) (time.Time, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+	buf := make([]byte, 64)
+	n, _ := f.Read(buf)
+	stamp, _, _ := strings.Cut(string(buf[:n]), " ")
+	t, err := time.Parse(time.RFC3339, stamp)
+	return t, err == nil
+}
+
+func (l *rotatingLog) printf(format string, args ...any) {
+	line := time.Now().Format(time.RFC3339) + " " + strings.TrimRight(fmt.Sprintf(format, args...), "\n") + "\n"
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return
+	}
+	if (l.maxSize > 0 && l.size+int64(len(line)) > l.maxSize && l.size > 0) ||
+		(l.maxAge > 0 && time.Since(l.opened) > l.maxAge) {
+		if err := l.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot rotate %s: %v\n", l.path, err)
+		}
+	}
+	n, _ := l.file.WriteString(line)
+	l.size += int64(n)
+}
+
+func (l *rotatingLog) rotate() error {
+	l.file.Close()
+	l.file = nil
+	rotated := l.path + "." + time.Now().Format("20060102-150405.000")
+	if err := os.Rename(l.path, rotated); err != nil {
+		l.open()
+		return err
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	return l.prune()
+}
+
+// prune deletes all but the newest keep rotated files. The timestamp
+// suffix sorts chronologically.
+func (l *rotatingLog) prune() error {
+	if l.keep <= 0 {
+		return nil
+	}
+	old, err := filepath.Glob(l.path + ".2*")
+	if err != nil {
+		return err
+	}
+	slices.Sort(old)
+	for len(old) > l.keep {
+		if err := os.Remove(old[0]); err != nil {
+			return err
+		}
+		old = old[1:]
+	}
+	return nil
+}
+
+func (l *rotatingLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// logSink receives the operation log of a run (--log-file, --log-target).
+type logSink interface {
+	printf(format string, args ...any)
+	close()
+}
+
+// Log targets accepted by --log-target.
+const (
+	logTargetFile     = "file"
+	logTargetSyslog   = "syslog"
+	logTargetJournald = "journald"
+	logTargetEventLog = "eventlog"
+)
+
+// openLogTarget opens the sink named by --log-target. An empty target
+// means a file if --log-file is set and no log otherwise.
+func openLogTarget(target, path string, maxSize int64, maxAge time.Duration, keep int) (logSink, error) {
+	if target == "" && path != "" {
+		target = logTargetFile
+	}
+	switch target {
+	case "":
+		return nil, nil
+	case logTargetFile:
+		if path == "" {
+			return nil, fmt.Errorf("--log-target=file needs --log-file")
+		}
+		return openRotatingLog(path, maxSize, maxAge, keep)
+	case logTargetSyslog:
+		return openSyslog()
+	case logTargetJournald:
+		return openJournald()
+	case logTargetEventLog:
+		return openEventLog()
+	}
+	return nil, fmt.Errorf("invalid --log-target %q (want file, syslog, journald or eventlog)", target)
+}
+
+// isErrorLine tells system loggers which lines deserve error priority.
+func isErrorLine(msg string) bool {
+	return strings.HasPrefix(msg, "Error") || strings.HasPrefix(msg, "[ERROR]")
+}
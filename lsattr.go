@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// lsattrFlag copies Linux inode attributes (chattr(1): immutable,
+// append-only, nocow and so on) to copies on filesystems that take them.
+var lsattrFlag bool
+
+// copyAttrs gives dst the attributes of src once nothing more is written
+// to it; immutable and append-only would block that. Attributes the target
+// filesystem or our privileges don't allow are reported as a warning.
+func copyAttrs(src, dst string) {
+	if !lsattrFlag {
+		return
+	}
+	attrs, err := getAttrs(src)
+	if err != nil || attrs == 0 {
+		return
+	}
+	if err := setAttrs(dst, attrs); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot set attributes %s on %s: %v\n", attrString(attrs), dst, err)
+	}
+}
+
+// presetAttrs gives the still empty copy dst the attributes of src that
+// only work when set before any data is written (nocow).
+func presetAttrs(src, dst string) {
+	if !lsattrFlag {
+		return
+	}
+	if attrs, err := getAttrs(src); err == nil && attrs&attrNoCow != 0 {
+		// Filesystems without copy-on-write simply refuse it; the
+		// warning comes with the rest of the attributes later
+		setAttrs(dst, attrNoCow)
+	}
+}
+
+// Inode attributes copied by --lsattr, as chattr(1) names them.
+const (
+	attrCompress  = 0x00000004 // c
+	attrSync      = 0x00000008 // S
+	attrImmutable = 0x00000010 // i
+	attrAppend    = 0x00000020 // a
+	attrNoDump    = 0x00000040 // d
+	attrNoAtime   = 0x00000080 // A
+	attrDirSync   = 0x00010000 // D
+	attrNoCow     = 0x00800000 // C
+)
+
+var attrLetters = []struct {
+	attr   uint32
+	letter byte
+}{
+	{attrSync, 'S'}, {attrDirSync, 'D'}, {attrImmutable, 'i'}, {attrAppend, 'a'},
+	{attrNoDump, 'd'}, {attrNoAtime, 'A'}, {attrCompress, 'c'}, {attrNoCow, 'C'},
+}
+
+// attrString spells attrs the way lsattr(1) does, e.g. "ia".
+func attrString(attrs uint32) string {
+	var b []byte
+	for _, l := range attrLetters {
+		if attrs&l.attr != 0 {
+			b = append(b, l.letter)
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const lsattrSupported = true
+
+// FS_IOC_GETFLAGS and FS_IOC_SETFLAGS are declared with a long argument,
+// which sets the size encoded in them, though the kernel passes an int.
+const (
+	ioctlGetFlags = 2<<30 | unsafe.Sizeof(uintptr(0))<<16 | 'f'<<8 | 1
+	ioctlSetFlags = 1<<30 | unsafe.Sizeof(uintptr(0))<<16 | 'f'<<8 | 2
+)
+
+// attrsCopied are the attributes --lsattr carries over; the rest describe
+// how the filesystem stores the file rather than what the user asked for.
+const attrsCopied = attrCompress | attrSync | attrImmutable | attrAppend | attrNoDump | attrNoAtime | attrDirSync | attrNoCow
+
+// getAttrs returns the copied attributes of path.
+func getAttrs(path string) (uint32, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var attrs uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), ioctlGetFlags, uintptr(unsafe.Pointer(&attrs))); errno != 0 {
+		return 0, errno
+	}
+	return attrs & attrsCopied, nil
+}
+
+// setAttrs adds attrs to those path already has.
+func setAttrs(path string, attrs uint32) error {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var current uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), ioctlGetFlags, uintptr(unsafe.Pointer(&current))); errno != 0 {
+		return errno
+	}
+	if current&attrs == attrs {
+		return nil
+	}
+	current |= attrs
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), ioctlSetFlags, uintptr(unsafe.Pointer(&current))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+const lsattrSupported = false
+
+func getAttrs(path string) (uint32, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func setAttrs(path string, attrs uint32) error {
+	return errors.ErrUnsupported
+}
@@ -1,29 +1,71 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
+	"maps"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 var (
 	overallProgress int64
 	overallSize     int64
-	skipped         int
-	copied          int
+	skipped         int64
+	copied          int64
 	startTime       time.Time
 	copyFlag        bool
 	moveFlag        bool
 	applyFlag       bool
 	sourceFlag      string
 	targetFlag      string
+	tuiFlag         bool
+	webFlag         string
+	notifyFlag      bool
+	webhookFlag     string
+	webhookErrors   bool
+	orderFlag       string
+	interactiveFlag bool
+	undoFlag        bool
+	inplaceFlag     bool
+	appendFlag      bool
+	noPrescanFlag   bool
+
+	// pruneEmptyDirsFlag leaves target directories to be created by the
+	// files copied into them; dirsOnlyFlag copies no files at all.
+	pruneEmptyDirsFlag bool
+	dirsOnlyFlag       bool
+
+	// existingFlag updates changed target files instead of skipping them,
+	// and creates nothing new. ignoreExistingFlag names the default of
+	// never touching an existing file; with both only deletions remain.
+	existingFlag       bool
+	ignoreExistingFlag bool
+
+	// modifyWindowFlag is --modify-window in seconds; see modifyWindow.
+	modifyWindowFlag int
+
+	// splitSizeFlag is --split-size as given; see splitSize.
+	splitSizeFlag string
+
+	// progressInterval is the minimum time between progress redraws
+	// (--progress-interval), shared by every file being copied.
+	progressInterval = 65 * time.Millisecond
+	lastProgressDraw int64 // unix nanoseconds
 )
 
 // silentWriter tracks progress without printing
@@ -36,34 +78,61 @@ func (s *silentWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
-// progressWriter tracks and displays progress for a file
+// progressWriter tracks and displays progress for a file. Writes fail once
+// ctx, the context of the transfer attempt, is done.
 type progressWriter struct {
-	fileName   string
-	total      int64
-	current    int64
-	lastUpdate time.Time
+	fileName string
+	total    int64
+	current  int64
+	ctx      context.Context
 }
 
 func (w *progressWriter) Write(p []byte) (n int, err error) {
+	if err := control.checkpoint(); err != nil {
+		return 0, err
+	}
+	if w.ctx != nil && w.ctx.Err() != nil {
+		return 0, context.Cause(w.ctx)
+	}
 	n = len(p)
 	atomic.AddInt64(&w.current, int64(n))
 	atomic.AddInt64(&overallProgress, int64(n))
 
-	// Throttle updates to avoid excessive output
+	if screen != nil {
+		screen.fileProgress(atomic.LoadInt64(&w.current))
+		return n, nil
+	}
+	if plainProgress() {
+		return n, nil
+	}
+
+	// Throttle updates to avoid excessive output. The interval is global,
+	// so concurrent copies redraw once between them rather than each.
 	now := time.Now()
-	if now.Sub(w.lastUpdate) < 65*time.Millisecond {
+	last := atomic.LoadInt64(&lastProgressDraw)
+	if now.UnixNano()-last < int64(progressInterval) || !atomic.CompareAndSwapInt64(&lastProgressDraw, last, now.UnixNano()) {
 		return n, nil
 	}
-	w.lastUpdate = now
+	throughput.observe(now)
 
 	current := atomic.LoadInt64(&w.current)
-	pct := (current * 100) / w.total
-	if pct > 100 {
-		pct = 100
+	pct := percent(current, w.total)
+
+	// Calculate speed
+	speed := float64(current) / 1024 / 1024 // MB
+	speedStr := fmt.Sprintf("%.1f MB/s", speed)
+
+	// Fit the line to the terminal: the name gets up to a third of the
+	// width and the bar takes what is left, so nothing wraps.
+	width := 80
+	if cols, _, err := termSize(int(os.Stderr.Fd())); err == nil && cols > 0 {
+		width = cols
 	}
+	name := truncate(w.fileName, max(10, width/3))
+	fixed := len(" 100% [] ()") + len(speedStr)
+	barWidth := min(max(width-2-fixed-len([]rune(name)), 5), 60)
 
 	// Create animated progress bar with moving effect
-	barWidth := 40
 	filledWidth := int(pct * int64(barWidth) / 100)
 	if filledWidth > barWidth {
 		filledWidth = barWidth
@@ -77,20 +146,36 @@ func (w *progressWriter) Write(p []byte) (n int, err error) {
 	if emptyWidth < 0 {
 		emptyWidth = 0
 	}
-	bar := "[" + strings.Repeat("█", filledWidth) + animFrame + strings.Repeat(" ", emptyWidth) + "]"
-
-	// Calculate speed
-	speed := float64(current) / 1024 / 1024 // MB
-	speedStr := fmt.Sprintf("%.1f MB/s", speed)
+	bar := "[" + colorize(os.Stderr, ansiGreen, strings.Repeat("█", filledWidth)+animFrame) + strings.Repeat(" ", emptyWidth) + "]"
 
-	output := fmt.Sprintf("%s %3d%% %s (%s)", w.fileName, pct, bar, speedStr)
+	output := fmt.Sprintf("%s %3d%% %s (%s)", name, pct, bar, speedStr)
 
 	// Use carriage return + clear line to ensure single line output
-	fmt.Fprintf(os.Stderr, "\r%s", output)
+	fmt.Fprintf(os.Stderr, "\r%s\x1b[K", output)
 
 	return n, nil
 }
 
+// quietOps drops per-file lines meant for stdout; the estimate subcommand
+// walks the tree with the transfer code but only wants its own report.
+var quietOps bool
+
+// logOp prints an operation line to w, or appends it to the TUI log when the
+// full-screen interface is active.
+func logOp(w io.Writer, format string, args ...any) {
+	if quietOps && w == os.Stdout {
+		return
+	}
+	if runLog != nil {
+		runLog.printf(format, args...)
+	}
+	if screen != nil {
+		screen.logf(format, args...)
+		return
+	}
+	fmt.Fprint(w, colorTag(w, fmt.Sprintf(format, args...)))
+}
+
 // cleanFilename removes numbered variants like (1), (2), (123), (1) with spaces, etc.
 func cleanFilename(filename string) string {
 	// Match patterns like " (1)", " (2)", "(1)", "(123)", etc.
@@ -98,12 +183,160 @@ func cleanFilename(filename string) string {
 	return re.ReplaceAllString(filename, "")
 }
 
+// envFlagName maps a flag name to its MIRROR_* environment variable,
+// e.g. "max-open-files" -> "MIRROR_MAX_OPEN_FILES".
+func envFlagName(name string) string {
+	return "MIRROR_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// repeatableFlag is a flag that may be given more than once. Its MIRROR_*
+// variable holds a list of values, separated like PATH.
+type repeatableFlag interface {
+	repeatable()
+}
+
+// applyEnvFlags sets each flag of fs that the command line left out from
+// its MIRROR_* environment variable. It runs once fs is parsed, so
+// command-line flags win; every subcommand calls it for its own flags.
+func applyEnvFlags(fs *flag.FlagSet) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	var firstErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envFlagName(f.Name))
+		if !ok || given[f.Name] || firstErr != nil {
+			return
+		}
+		values := []string{value}
+		if _, ok := f.Value.(repeatableFlag); ok {
+			values = filepath.SplitList(value)
+		}
+		for _, v := range values {
+			if err := fs.Set(f.Name, v); err != nil {
+				firstErr = fmt.Errorf("invalid value %q for %s: %v", v, envFlagName(f.Name), err)
+				return
+			}
+		}
+	})
+	return firstErr
+}
+
+// parseSubcommand parses the flags of a subcommand and then fills in the
+// ones not given from the environment, exiting on errors like fs.Parse.
+func parseSubcommand(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	if err := applyEnvFlags(fs); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(2)
+	}
+}
+
 func main() {
 	flag.BoolVar(&copyFlag, "copy", false, "copy files from source to target")
 	flag.BoolVar(&moveFlag, "move", false, "move files from source to target")
 	flag.BoolVar(&applyFlag, "apply", false, "apply the copy/move operation (without this flag, only lists files)")
 	flag.StringVar(&sourceFlag, "source", "", "source directory")
 	flag.StringVar(&targetFlag, "target", "", "target directory")
+	flag.StringVar(&orderFlag, "order", orderDiscovery, "transfer order: largest-first, smallest-first, alpha or discovery")
+	flag.Var(chmodFlag{}, "chmod", "rewrite target permissions, e.g. D755,F644 or ug+rw,o-w (D/F limit an item to directories/files; repeatable)")
+	flag.StringVar(&timesFlag, "times", timesMtime, "source timestamps to keep: none, mtime, or all (adds access and, on Windows and macOS, creation time)")
+	flag.StringVar(&timePrecisionFlag, "time-precision", precisionNanosecond, "compare and set timestamps to the nanosecond, or only to the second for targets that drop fractions")
+	flag.BoolVar(&fileFlagsFlag, "fileflags", false, "copy BSD/macOS file flags such as uchg, hidden and nodump (see chflags(1))")
+	flag.BoolVar(&lsattrFlag, "lsattr", false, "copy Linux file attributes such as immutable, append-only and nocow (see chattr(1))")
+	flag.BoolVar(&winAttrsFlag, "win-attrs", false, "copy the Windows hidden, system, archive and read-only attributes")
+	flag.BoolVar(&clearArchiveFlag, "clear-archive", false, "clear the Windows archive attribute of each source file once it is copied")
+	flag.StringVar(&mountsFlag, "mounts", mountsInclude, "mount points under the source: include, skip, or list without descending")
+	flag.BoolVar(&noPrescanFlag, "no-prescan", false, "skip the initial size scan and report progress as files/bytes so far")
+	flag.DurationVar(&scanCacheTTL, "scan-cache", 0, "reuse a saved source scan younger than this (e.g. 10m) instead of walking the source again")
+	flag.BoolVar(&interactiveFlag, "interactive", false, "review the change set and confirm before applying")
+	flag.BoolVar(&inplaceFlag, "inplace", false, "write directly to destination files instead of a temporary file renamed into place")
+	flag.BoolVar(&pruneEmptyDirsFlag, "prune-empty-dirs", false, "don't create target directories that would end up with no files in them")
+	flag.BoolVar(&dirsOnlyFlag, "dirs-only", false, "replicate only the directory tree, without any files")
+	flag.BoolVar(&ignoreExistingFlag, "ignore-existing", false, "leave files that already exist on the target alone; the default unless --existing is given")
+	flag.IntVar(&modifyWindowFlag, "modify-window", 0, "treat modification times up to this many `seconds` apart as equal (2 suits FAT/exFAT targets)")
+	flag.BoolVar(&existingFlag, "existing", false, "only update files that already exist on the target (when size or mtime differ), never create new ones")
+	flag.StringVar(&onCollisionFlag, "on-collision", collisionSkip, "when a target file exists and differs: skip, or rename to copy it as \"name (1).ext\"")
+	flag.IntVar(&versionsFlag, "versions", 0, "update changed target files, keeping the `N` most recent replaced copies as file.~1~ to file.~N~")
+	flag.StringVar(&splitSizeFlag, "split-size", "", "store files larger than this (e.g. 4G) as numbered chunks plus a manifest; copying back without it joins them")
+	flag.Var(refDirFlag{}, "compare-dest", "don't transfer files missing from the target but unchanged in this `dir` (relative to the target; repeatable)")
+	flag.Var(refDirFlag{copy: true}, "copy-dest", "like --compare-dest, but copy unchanged files locally from this `dir` instead of from the source")
+	flag.BoolVar(&linksFlag, "links", false, "recreate symlinks on the target instead of leaving them out")
+	flag.StringVar(&rewriteLinksFlag, "rewrite-links", rewriteKeep, "with --links, absolute link targets inside the source: keep, point them at the same path under the target, or make them relative")
+	flag.StringVar(&danglingLinksFlag, "dangling-links", danglingKeep, "with --links, symlinks whose target doesn't exist: keep, skip, or error")
+	flag.BoolVar(&appendFlag, "append", false, "when an existing destination file is a prefix of the source, copy only the new tail (copy only)")
+	flag.Var(filterFlag{"- "}, "exclude", "skip source entries matching this rsync-style pattern (repeatable)")
+	flag.Var(filterFlag{"+ "}, "include", "don't exclude entries matching this pattern (repeatable; first matching rule wins)")
+	flag.Var(filterFlag{""}, "filter", "add an rsync filter rule, e.g. \"- *.tmp\", \". rules.txt\" or \": .rsync-filter\" (repeatable)")
+	flag.StringVar(&excludeDirLargerThanFlag, "exclude-dir-larger-than", "", "skip source directories whose contents add up to more than this `size` (e.g. 20G)")
+	filesFromFile := flag.String("files-from", "", "only transfer the paths listed in this file (one per line, relative to the source)")
+	retryFailed := flag.String("retry-failed", "", "only transfer the paths that failed in an earlier run, from the list it saved")
+	flag.StringVar(&hashFlag, "hash", hashFlag, "checksum algorithm: xxh3, blake3, sha256 or md5")
+	flag.StringVar(&writeChecksumsFlag, "write-checksums", "", "after the run, write a sha256sum/md5sum-style `file` listing every target file (relative names go in the target)")
+	flag.BoolVar(&verifyFlag, "verify", false, "re-read every copy and compare its checksum with the source")
+	flag.DurationVar(&daemonInterval, "interval", 15*time.Minute, "time between runs of daemon")
+	flag.DurationVar(&lockWait, "lock-wait", 0, "wait up to this long (e.g. 10m) for another run writing to the same target instead of exiting")
+	flag.BoolVar(&assertSourceReadonlyFlag, "assert-source-readonly", false, "refuse anything that would modify the source (such as --move) and read it without touching access times")
+	flag.StringVar(&outputFlag, "output", outputText, "what copy/move prints on stdout: text, or json for only a final summary document (everything else goes to stderr)")
+	flag.BoolVar(&failFastFlag, "fail-fast", false, "stop at the first error instead of reporting it and carrying on with the rest")
+	flag.DurationVar(&fileTimeout, "file-timeout", 0, "fail a file whose transfer takes longer than this (e.g. 10m), for example on a hung network mount")
+	flag.DurationVar(&runTimeout, "run-timeout", 0, "abort the run after this long (e.g. 6h)")
+	flag.DurationVar(&stallTimeout, "stall-timeout", 0, "fail a file when its transfer moves no data, or less than --min-rate, for this long (e.g. 2m)")
+	flag.StringVar(&minRateFlag, "min-rate", "", "with --stall-timeout, the slowest acceptable `rate` per second, e.g. 100K")
+	flag.IntVar(&retriesFlag, "retries", 0, "retry a failed file this many times")
+	flag.DurationVar(&retryWaitFlag, "retry-wait", 5*time.Second, "pause between --retries")
+	flag.BoolVar(&deleteFlag, "delete", false, "delete target files and directories that are not in the source (copy only)")
+	flag.BoolVar(&deleteBeforeFlag, "delete-before", false, "delete before transferring, to free space first (implies --delete)")
+	flag.BoolVar(&deleteDuringFlag, "delete-during", false, "delete in each directory as the walk reaches it (implies --delete)")
+	flag.BoolVar(&deleteAfterFlag, "delete-after", false, "delete only after every transfer succeeded; the default (implies --delete)")
+	flag.StringVar(&maxDeleteFlag, "max-delete", "", "abort before deleting anything if --delete would remove more than `N` files (or N% of the target)")
+	flag.BoolVar(&deleteExcludedFlag, "delete-excluded", false, "with --delete, also delete target entries matching the exclude rules")
+	flag.BoolVar(&undoFlag, "undo", false, "journal every change (keeping deleted files) so the run can be reverted with 'undo'")
+	maxOpenFiles := flag.Int("max-open-files", 0, "maximum number of files held open at once (0 = derived from ulimit -n)")
+	maxIOPS := flag.Int("max-iops", 0, "maximum file opens/creates/renames per second (0 = unlimited)")
+	flag.BoolVar(&onlyWhenIdleFlag, "only-when-idle", false, "wait while other processes keep the CPU busy or the desktop is in use")
+	flag.BoolVar(&pauseOnBatteryFlag, "pause-on-battery", false, "wait while the machine runs on battery power")
+	flag.IntVar(&idleThreshold, "idle-threshold", 20, "CPU use by other processes, in percent, above which --only-when-idle waits")
+	flag.StringVar(&maxTransferFlag, "max-transfer", "", "stop cleanly before transferring more than this much data, e.g. 50G")
+	flag.Int64Var(&maxFilesFlag, "max-files", 0, "stop cleanly after transferring this many files (0 = no limit)")
+	flag.StringVar(&minFreeFlag, "min-free", "", "stop before the target's free space would drop below this, e.g. 10G")
+	flag.IntVar(&checkpointFiles, "checkpoint-files", 1000, "save a resume checkpoint every this many files (0 = only by time)")
+	flag.DurationVar(&checkpointInterval, "checkpoint-interval", 30*time.Second, "save a resume checkpoint at least this often (0 = only by count)")
+	flag.BoolVar(&resumeFlag, "resume", false, "continue after the checkpoint of an interrupted run instead of rechecking everything")
+	bwLimit := flag.String("bwlimit", "", "limit transfers to this many bytes per second, e.g. 5M, or by time of day, e.g. \"01:00,off 06:00,5M\"")
+	bufferSize := flag.String("buffer-size", "1M", "size of each pooled copy buffer (e.g. 256K, 4M)")
+	logFile := flag.String("log-file", "", "also append operations and results to this file")
+	logTarget := flag.String("log-target", "", "where to log operations and results: file (--log-file), syslog, journald or eventlog (Windows: summaries and errors only)")
+	logMaxSize := flag.String("log-max-size", "10M", "rotate the --log-file once it grows past this size (0 = never)")
+	logMaxAge := flag.Duration("log-max-age", 0, "rotate the --log-file once it is older than this (e.g. 24h; 0 = never)")
+	logKeep := flag.Int("log-keep", 5, "number of rotated log files to keep (0 = all)")
+	ionice := flag.Bool("ionice", false, "run with background (idle) I/O priority")
+	pprofAddr := flag.String("pprof", "", "serve net/http/pprof on this address (e.g. :6060 for localhost only)")
+	traceFile := flag.String("trace", "", "write a runtime execution trace to this file")
+	chaosRate := flag.Float64("chaos", 0, "fault injection: probability of failing each I/O operation (testing only)")
+	chaosSeed := flag.Uint64("chaos-seed", 1, "random seed for --chaos")
+	flag.Usage = usage
+	flag.BoolVar(&itemizeFlag, "itemize", false, "log rsync-style change codes (new, size, time, permissions, deletion) instead of operation tags")
+	flag.BoolVar(&itemizeFlag, "i", false, "shorthand for --itemize")
+	flag.BoolVar(&tuiFlag, "tui", false, "show a full-screen interface while applying a copy/move (keys: p pause, s skip, q abort)")
+	flag.StringVar(&progressFlag, "progress", progressBar, "progress display: bar, or plain-periodic for a plain \"X% done, Y MB/s, ETA Z\" line every --progress-interval (10s unless set to 1s or more)")
+	flag.DurationVar(&progressInterval, "progress-interval", progressInterval, "minimum time between progress updates (e.g. 1s or 30s for slow consoles and CI logs)")
+	flag.BoolVar(&noColorFlag, "no-color", false, "disable colored output (also set by NO_COLOR)")
+	flag.StringVar(&controlSocketFlag, "control-socket", "", "accept JSON-RPC status/pause/resume/cancel requests on this unix socket")
+	flag.StringVar(&webFlag, "web", "", "serve a live progress dashboard on this address (e.g. :8080, 127.0.0.1:8080 for localhost only); open the URL it prints, which carries an access token")
+	flag.BoolVar(&notifyFlag, "notify", false, "show a desktop notification when the copy/move completes or fails")
+	flag.StringVar(&webhookFlag, "webhook", "", "POST a JSON summary to this URL when the copy/move finishes")
+	flag.BoolVar(&webhookErrors, "webhook-errors", false, "also POST an event for every error (only with --webhook)")
+	flag.StringVar(&proxyFlag, "proxy", "", "send webhook and SMTP traffic through this http://, https:// or socks5:// proxy (default: HTTP_PROXY, HTTPS_PROXY, ALL_PROXY)")
+	flag.StringVar(&caCertFlag, "ca-cert", "", "PEM file of extra CA certificates to trust for webhook, SMTP and proxy TLS")
+	flag.StringVar(&clientCertFlag, "client-cert", "", "PEM client certificate to present over TLS")
+	flag.StringVar(&clientKeyFlag, "client-key", "", "PEM private key for --client-cert (default: read from the --client-cert file)")
+	flag.BoolVar(&insecureSkipVerify, "insecure-skip-verify", false, "do not verify server certificates (unsafe; for testing self-signed servers only)")
+	flag.StringVar(&mailConfig.addr, "smtp-host", "", "SMTP server (host:port) used to email a run report")
+	flag.StringVar(&mailConfig.user, "smtp-user", "", "SMTP username")
+	flag.StringVar(&mailConfig.password, "smtp-password", "", "SMTP password (prefer MIRROR_SMTP_PASSWORD or --credential-helper)")
+	flag.StringVar(&credentialHelper, "credential-helper", "", "git credential helper for the SMTP password: osxkeychain, manager, libsecret or a command")
+	flag.StringVar(&mailConfig.from, "mail-from", "", "sender address for the run report (default: --smtp-user)")
+	flag.StringVar(&mailConfig.to, "mail-to", "", "comma-separated recipients of the run report")
 	
 	// Tool flags
 	duplicatesFlag := flag.Bool("duplicates", false, "find duplicate files with (1) in name")
@@ -111,41 +344,217 @@ func main() {
 	orphanedFlag := flag.Bool("orphaned", false, "remove orphaned XMP files (only with --xmp)")
 	
 	flag.Parse()
+	var command string
+	var operands []string
+	if sharedFlagCommands[flag.Arg(0)] {
+		command = flag.Arg(0)
+		commandArg = len(os.Args) - flag.NArg()
+		operands = parseInterspersed(flag.CommandLine, flag.Args()[1:])
+	}
+	if err := applyEnvFlags(flag.CommandLine); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
 	startTime = time.Now()
+	openFiles = newFDLimiter(*maxOpenFiles)
+	fileOps = newOpRateLimiter(*maxIOPS)
+	if idleThreshold < 1 || idleThreshold > 100 {
+		fmt.Fprintf(os.Stderr, "Error: --idle-threshold must be between 1 and 100\n")
+		exit(1)
+	}
+	// Every subcommand that compares times uses the window
+	if modifyWindowFlag < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --modify-window must not be negative\n")
+		exit(1)
+	}
+	modifyWindow = time.Duration(modifyWindowFlag) * time.Second
+	if err := parseMinFree(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := parseExcludeDirLargerThan(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := parseStall(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := parseQuota(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if schedule, err := parseBwLimit(*bwLimit); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	} else {
+		bandwidth.schedule = schedule
+	}
+	if err := setBufferSize(*bufferSize); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := validateHash(hashFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := validateProxy(proxyFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := validateMail(mailConfig); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := useCredentialHelper(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := useTLSFlags(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if insecureSkipVerify {
+		fmt.Fprintln(os.Stderr, "Warning: --insecure-skip-verify is set; TLS certificates will not be checked")
+	}
+	if err := useSumFileHash(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if *retryFailed != "" && *filesFromFile != "" {
+		fmt.Fprintf(os.Stderr, "Error: cannot specify both --files-from and --retry-failed\n")
+		exit(1)
+	}
+	if list := cmp.Or(*retryFailed, *filesFromFile); list != "" {
+		var err error
+		if filesFrom, err = readFileList(list); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot read file list: %v\n", err)
+			exit(1)
+		}
+	}
+	if *logFile != "" || *logTarget != "" {
+		maxSize, err := parseSize(*logMaxSize)
+		if err == nil {
+			runLog, err = openLogTarget(*logTarget, *logFile, maxSize, *logMaxAge, *logKeep)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot open log: %v\n", err)
+			exit(1)
+		}
+		exitHooks = append(exitHooks, runLog.close)
+	}
+	if *pprofAddr != "" {
+		if err := startPprof(*pprofAddr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot start pprof: %v\n", err)
+			exit(1)
+		}
+	}
+	if *traceFile != "" {
+		if err := startTrace(*traceFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot start trace: %v\n", err)
+			exit(1)
+		}
+	}
+	if *chaosRate > 0 {
+		enableChaos(*chaosRate, *chaosSeed)
+	}
+	if *ionice {
+		if err := lowerIOPriority(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot lower I/O priority: %v\n", err)
+		}
+	}
 
 	// Determine which operation to run
-	if *duplicatesFlag || *xmpFlag {
+	if command != "" {
+		runCommand(command, operands)
+	} else if flag.Arg(0) == "bench" {
+		runBench(flag.Args()[1:])
+	} else if flag.Arg(0) == "report" {
+		runReport(flag.Args()[1:])
+	} else if flag.Arg(0) == "estimate" {
+		runEstimate(flag.Args()[1:])
+	} else if flag.Arg(0) == "plan" {
+		runPlan(flag.Args()[1:])
+	} else if flag.Arg(0) == "apply" {
+		runApplyPlan(flag.Args()[1:])
+	} else if flag.Arg(0) == "undo" {
+		runUndo(flag.Args()[1:])
+	} else if flag.Arg(0) == "robocopy" {
+		runRobocopy(flag.Args()[1:])
+	} else if flag.Arg(0) == "verify" {
+		runVerify(flag.Args()[1:])
+	} else if flag.Arg(0) == "backup" {
+		runBackup(flag.Args()[1:])
+	} else if flag.Arg(0) == "restore" {
+		runRestore(flag.Args()[1:])
+	} else if *duplicatesFlag || *xmpFlag {
 		// Tool operations (duplicates or xmp)
 		runToolOperation(*duplicatesFlag, *xmpFlag, targetFlag, applyFlag, *orphanedFlag)
 	} else if copyFlag || moveFlag {
 		// Copy/move operations
 		runCopyMoveOperation()
 	} else {
-		fmt.Fprintf(os.Stderr, "Usage: %s (--copy | --move) --source <source> --target <target> [--apply]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s (copy | move | sync) [flags] <source> <target>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "   or: %s diff [--verify] <source> <target>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "   or: %s daemon [--interval 15m] [flags] <source> <target>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "   or: %s (--copy | --move) --source <source> --target <target> [--apply]\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "   or: %s (--duplicates | --xmp) --target <directory> [--apply]\n", os.Args[0])
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "   or: %s plan [--move] [-o plan.json] <source> <target>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "   or: %s estimate [--delete] [--top 10] <source> <target>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "   or: %s report [--json] [--top 10] [--depth 1] <dir>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "   or: %s apply <plan.json>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "   or: %s undo --target <directory> [run-id]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "   or: %s bench [--size 256M] [--files 500] [--file-size 16K] <source> <target>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "   or: %s verify [--size-only] [--hash xxh3] <source> <target>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "   or: %s backup [--dry-run] [--dedup [--compress]] <source> <backup-dir>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "   or: %s restore [--as-of DATE | --list] <backup-dir> [<target>]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "   or: %s robocopy <source> <target> [/E] [/MIR] [/PURGE] [/XD dir...] [/XF file...] [/R:n] [/W:n] [/MOV] [/L]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Every flag can also be set via MIRROR_<FLAG> environment variables (e.g. MIRROR_SOURCE); repeatable ones take a list separated like PATH.\n")
+		exit(1)
 	}
+	exit(0)
+}
+
+// usage prints the flag defaults, leaving out hidden testing flags.
+func usage() {
+	visible := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			visible.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+	visible.SetOutput(os.Stderr)
+	visible.PrintDefaults()
 }
 
 func runToolOperation(duplicates, xmp bool, dir string, apply bool, orphaned bool) {
+	if assertSourceReadonlyFlag && apply {
+		fmt.Fprintf(os.Stderr, "Error: --assert-source-readonly cannot be used with --duplicates or --xmp, which change --dir\n")
+		exit(1)
+	}
 	if duplicates && xmp {
 		fmt.Fprintf(os.Stderr, "Error: cannot specify both --duplicates and --xmp\n")
-		os.Exit(1)
+		exit(1)
 	}
 
 	if orphaned && !xmp {
 		fmt.Fprintf(os.Stderr, "Error: --orphaned can only be used with --xmp\n")
-		os.Exit(1)
+		exit(1)
 	}
 
 	if dir == "" {
 		fmt.Fprintf(os.Stderr, "Error: --dir flag is required\n")
-		os.Exit(1)
+		exit(1)
 	}
 
 	if _, err := os.Stat(dir); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: Directory '%s' not found\n", dir)
-		os.Exit(1)
+		exit(1)
+	}
+
+	if apply {
+		startUndo(dir)
 	}
 
 	if xmp {
@@ -156,20 +565,137 @@ func runToolOperation(duplicates, xmp bool, dir string, apply bool, orphaned boo
 }
 
 func runCopyMoveOperation() {
+	if err := useOutputFormat(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+
 	// Validate flags
 	if !copyFlag && !moveFlag {
 		fmt.Fprintf(os.Stderr, "Usage: %s (--copy | --move) --source <source> --target <target> [--apply]\n", os.Args[0])
-		os.Exit(1)
+		exit(1)
 	}
 
 	if copyFlag && moveFlag {
 		fmt.Fprintf(os.Stderr, "Error: cannot specify both --copy and --move\n")
-		os.Exit(1)
+		exit(1)
 	}
 
 	if sourceFlag == "" || targetFlag == "" {
 		fmt.Fprintf(os.Stderr, "Error: --source and --target flags are required\n")
-		os.Exit(1)
+		exit(1)
+	}
+
+	if err := validateOrder(orderFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := validateMounts(mountsFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := validateTimes(timesFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := validateTimePrecision(timePrecisionFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if fileFlagsFlag && !fileFlagsSupported {
+		fmt.Fprintf(os.Stderr, "Warning: --fileflags has no effect on this platform\n")
+	}
+	if lsattrFlag && !lsattrSupported {
+		fmt.Fprintf(os.Stderr, "Warning: --lsattr has no effect on this platform\n")
+	}
+	if (winAttrsFlag || clearArchiveFlag) && !winAttrsSupported {
+		fmt.Fprintf(os.Stderr, "Warning: --win-attrs and --clear-archive have no effect on this platform\n")
+	}
+	if clearArchiveFlag && moveFlag {
+		fmt.Fprintf(os.Stderr, "Error: --clear-archive can only be used with --copy\n")
+		exit(1)
+	}
+
+	if appendFlag && moveFlag {
+		fmt.Fprintf(os.Stderr, "Error: --append can only be used with --copy\n")
+		exit(1)
+	}
+
+	if err := validateCollision(onCollisionFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if onCollisionFlag == collisionRename && (existingFlag || versionsFlag > 0) {
+		fmt.Fprintf(os.Stderr, "Error: --on-collision=rename cannot be used with --existing or --versions\n")
+		exit(1)
+	}
+	if versionsFlag < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --versions must not be negative\n")
+		exit(1)
+	}
+
+	if err := parseSplitSize(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+
+	if pruneEmptyDirsFlag && dirsOnlyFlag {
+		fmt.Fprintf(os.Stderr, "Error: --prune-empty-dirs cannot be used with --dirs-only\n")
+		exit(1)
+	}
+
+	if err := validateRewriteLinks(rewriteLinksFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if rewriteLinksFlag != rewriteKeep && !linksFlag {
+		fmt.Fprintf(os.Stderr, "Error: --rewrite-links requires --links\n")
+		exit(1)
+	}
+	if err := validateDanglingLinks(danglingLinksFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if linksFlag && interactiveFlag {
+		fmt.Fprintf(os.Stderr, "Error: --links cannot be used with --interactive\n")
+		exit(1)
+	}
+	if err := validateProgress(progressFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if plainProgress() {
+		if tuiFlag {
+			fmt.Fprintf(os.Stderr, "Error: --progress=plain-periodic cannot be used with --tui\n")
+			exit(1)
+		}
+		noColorFlag = true
+	}
+
+	if err := checkSourceReadonly(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+
+	if err := resolveDeleteTiming(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if deleteFlag && moveFlag {
+		fmt.Fprintf(os.Stderr, "Error: --delete can only be used with --copy\n")
+		exit(1)
+	}
+	if deleteFlag && onCollisionFlag == collisionRename {
+		fmt.Fprintf(os.Stderr, "Error: --delete cannot be used with --on-collision=rename, which keeps every version\n")
+		exit(1)
+	}
+	if deleteExcludedFlag && !deleteFlag {
+		fmt.Fprintf(os.Stderr, "Error: --delete-excluded requires --delete\n")
+		exit(1)
+	}
+	if deleteExcludedFlag && filesFrom != nil {
+		fmt.Fprintf(os.Stderr, "Error: --delete-excluded cannot be used with --files-from or --retry-failed\n")
+		exit(1)
 	}
 
 	srcRoot := filepath.Clean(sourceFlag)
@@ -177,100 +703,543 @@ func runCopyMoveOperation() {
 
 	if _, err := os.Stat(srcRoot); err != nil {
 		fmt.Fprintf(os.Stderr, "Source does not exist: %s\n", srcRoot)
-		os.Exit(1)
+		exit(1)
 	}
 	if _, err := os.Stat(dstRoot); err != nil {
 		fmt.Fprintf(os.Stderr, "Target does not exist: %s\n", dstRoot)
-		os.Exit(1)
+		exit(1)
+	}
+	if err := checkOverlap(srcRoot, dstRoot); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := resolveRefDirs(dstRoot); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+
+	if maxDeleteFlag != "" && !deleteFlag {
+		fmt.Fprintf(os.Stderr, "Error: --max-delete requires --delete\n")
+		exit(1)
+	}
+	if deleteFlag {
+		if err := checkMaxDelete(srcRoot, dstRoot); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+	}
+
+	operation := beginTransfers(srcRoot, dstRoot)
+	if applyFlag {
+		startUndo(dstRoot)
 	}
 
 	// First pass: calculate total size
-	fmt.Fprintf(os.Stderr, "Calculating total size...\n")
-	filepath.WalkDir(srcRoot, func(path string, d fs.DirEntry, err error) error {
-		if err != nil || d.IsDir() {
-			return nil
+	if !noPrescanFlag {
+		scanSource(srcRoot)
+		fmt.Fprintf(os.Stderr, "Total size: %.2f MB\n", float64(overallSize)/1024/1024)
+	}
+
+	if interactiveFlag && applyFlag {
+		changes, err := collectChanges(srcRoot, dstRoot)
+		if err == nil && !confirmChanges(changes, operation, os.Stdin) {
+			fmt.Println("Nothing applied.")
+			return
 		}
-		if d.Type()&os.ModeSymlink == 0 {
-			if info, err := d.Info(); err == nil {
-				overallSize += info.Size()
+		if err == nil {
+			if tuiFlag {
+				screen = startTUI(fmt.Sprintf("lyphotos %s  %s -> %s", operation, srcRoot, dstRoot))
 			}
+			err = changes.apply()
+		}
+		if err == nil {
+			err = finishTransfers(srcRoot, dstRoot)
+		}
+		endTransfers(srcRoot, operation, err)
+		return
+	}
+
+	if tuiFlag && applyFlag {
+		screen = startTUI(fmt.Sprintf("lyphotos %s  %s -> %s", operation, srcRoot, dstRoot))
+	}
+
+	if deleteFlag && deleteTiming == deleteBefore {
+		if err := deleteExtraneous(srcRoot, dstRoot); err != nil {
+			endTransfers(srcRoot, operation, err)
+			return
+		}
+	}
+
+	// Second pass: list or apply copy/move. In discovery order files stream
+	// from the walk straight into transfers; other orders collect them
+	// first and transfer once the walk is done.
+	onDir := func(rel, dst string) error {
+		if pruneEmptyDirsFlag {
+			// Left to the files that need it, so empty ones never appear
+			return nil
+		}
+		logNewDir(rel)
+		if applyFlag {
+			return makeDir(dst)
 		}
 		return nil
-	})
-	fmt.Fprintf(os.Stderr, "Total size: %.2f MB\n", float64(overallSize)/1024/1024)
+	}
+	if err := startCheckpoints(srcRoot, dstRoot); err != nil {
+		endTransfers(srcRoot, operation, err)
+		return
+	}
+	var err error
+	if orderFlag == orderDiscovery {
+		err = pipeTransfers(srcRoot, dstRoot, onDir, runTransfer)
+	} else {
+		var pending []transferOp
+		err = walkTransfers(srcRoot, dstRoot, onDir, func(op transferOp) error {
+			pending = append(pending, op)
+			return nil
+		})
+		if err == nil {
+			sortTransfers(pending, orderFlag)
+			for _, op := range pending {
+				if err = runTransfer(op); err != nil {
+					break
+				}
+			}
+		}
+	}
+	if err == nil && deleteFlag && deleteTiming == deleteAfter {
+		// Deleting after a partial copy could lose the only good version
+		if atomic.LoadInt64(&failed) > 0 {
+			logOp(os.Stdout, "Deletions skipped: %d transfers failed\n", atomic.LoadInt64(&failed))
+		} else {
+			err = deleteExtraneous(srcRoot, dstRoot)
+		}
+	}
+	if err == nil && applyFlag {
+		err = finishTransfers(srcRoot, dstRoot)
+	}
 
-	// Second pass: list or apply copy/move
-	err := filepath.WalkDir(srcRoot, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
+	endTransfers(srcRoot, operation, err)
+}
+
+// finishTransfers does what is left once every file is in place: removing
+// the source directories a move emptied, writing --write-checksums and
+// restoring directory times.
+func finishTransfers(srcRoot, dstRoot string) error {
+	if moveFlag {
+		if err := removeEmptySourceDirs(srcRoot); err != nil {
+			return err
+		}
+	}
+	if writeChecksumsFlag != "" {
+		if err := writeSumFile(dstRoot); err != nil {
 			return err
 		}
+	}
+	return restoreDirTimes(srcRoot, dstRoot)
+}
+
+// beginTransfers publishes the run to the status front-ends and returns
+// the operation name ("copy" or "move").
+func beginTransfers(srcRoot, dstRoot string) string {
+	operation := "copy"
+	if moveFlag {
+		operation = "move"
+	}
+	if applyFlag {
+		lockTarget(dstRoot)
+	}
+	status.begin(operation, srcRoot, dstRoot)
+
+	// The first Ctrl-C or SIGTERM aborts after cleaning up the file in
+	// progress; a second one kills the process.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		signal.Stop(sigs)
+		control.abort()
+	}()
+
+	if webFlag != "" {
+		if err := startDashboard(webFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot start dashboard: %v\n", err)
+			exit(1)
+		}
+	}
+	if onlyWhenIdleFlag && applyFlag {
+		if err := startIdleMonitor(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+	}
+	if pauseOnBatteryFlag && applyFlag {
+		if err := startBatteryMonitor(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+	}
+	if applyFlag {
+		startRunTimeout()
+	}
+	if plainProgress() && applyFlag {
+		interval := plainProgressInterval
+		if progressInterval >= time.Second {
+			interval = progressInterval
+		}
+		startPlainProgress(interval)
+	}
+	if controlSocketFlag != "" {
+		if err := serveControl(controlSocketFlag, runControlMethod); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot open control socket: %v\n", err)
+			exit(1)
+		}
+	}
+	return operation
+}
+
+// endTransfers tears down the front-ends, fires completion notifications
+// and prints the final summary, exiting non-zero if the run failed.
+func endTransfers(srcRoot, operation string, err error) {
+	if screen != nil {
+		screen.stop()
+		screen = nil
+	}
+	stopPlainProgress()
+	stopRunTimeout()
+	if err == nil {
+		checkpoints.remove()
+	} else {
+		checkpoints.flush()
+	}
+
+	if errors.Is(err, errRunTimeout) {
+		finishRun("aborted")
+		logSummary("Run timeout reached: %d files %sd, %d skipped\n", copied, operation, skipped)
+		logRunBreakdown()
+		exit(1)
+	}
+	if errors.Is(err, errAborted) {
+		finishRun("aborted")
+		logSummary("Operation aborted: %d files %sd, %d skipped\n", copied, operation, skipped)
+		logRunBreakdown()
+		exit(1)
+	}
+	if errors.Is(err, errQuotaReached) {
+		finishRun("stopped")
+		logSummary("Transfer limit reached: %d files %sd (%s), %d skipped; run again to continue\n", copied, operation, formatSize(transferredBytes), skipped)
+		logRunBreakdown()
+		return
+	}
+	if err != nil {
+		status.recordError(srcRoot, err)
+		finishRun("failed")
+		fmt.Fprintln(os.Stderr, colorize(os.Stderr, ansiRed, "Error:"), err)
+		if runLog != nil {
+			runLog.printf("Error: %v", err)
+		}
+		exit(1)
+	}
+	if n := atomic.LoadInt64(&failed); n > 0 {
+		finishRun("failed")
+		logSummary("Operation finished with errors: %d files %sd, %d skipped, %d failed\n", copied, operation, skipped, n)
+		logRunBreakdown()
+		if list, err := writeRetryList(srcRoot, targetFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot save the list of failed files: %v\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Failed paths saved to %s; re-run with --retry-failed %s to try them again\n", list, list)
+		}
+		exit(1)
+	}
+	finishRun("done")
+
+	if applyFlag {
+		// The failures of an earlier run are dealt with now
+		if list, err := retryListPath(srcRoot, targetFlag); err == nil {
+			os.Remove(list)
+		}
+		if deleteFlag {
+			logSummary("Operation complete: %d files %sd, %d skipped, %d deleted\n", copied, operation, skipped, deleted)
+		} else {
+			logSummary("Operation complete: %d files %sd, %d skipped\n", copied, operation, skipped)
+		}
+		logRunBreakdown()
+	} else if deleteFlag {
+		fmt.Printf("Preview: %d files will be %sd, %d deleted\n", copied, operation, deleted)
+	} else {
+		fmt.Printf("Preview: %d files will be %sd\n", copied, operation)
+	}
+	if !applyFlag {
+		logDanglingLinks()
+	}
+}
+
+// logRunBreakdown follows the closing line with what was transferred by
+// file type and any dangling symlinks.
+func logRunBreakdown() {
+	logTypeStats()
+	logDanglingLinks()
+}
+
+// logSummary prints a run's closing line and records it in the log file.
+func logSummary(format string, args ...any) {
+	fmt.Printf(format, args...)
+	if runLog != nil {
+		runLog.printf(format, args...)
+	}
+}
+
+// walkTransfers walks srcRoot and reports what mirroring it into dstRoot
+// involves. WalkDir visits entries in lexical order, so the sequence is
+// the same on every filesystem. Files whose destination already exists are
+// logged and counted as skipped; missing directories are passed to onDir
+// and files to onFile. Symlinks are ignored.
+func walkTransfers(srcRoot, dstRoot string, onDir func(rel, dst string) error, onFile func(op transferOp) error) error {
+	filters.useRoot(srcRoot)
+	return walkSource(srcRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// An unreadable subtree is reported and left out unless the
+			// run should stop at the first error
+			rel, _ := filepath.Rel(srcRoot, path)
+			if d == nil || handleFailure(rel, err) != nil {
+				return err
+			}
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() && d.Name() == undoDirName {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), partSuffix) {
+			return nil
+		}
 
 		rel, err := filepath.Rel(srcRoot, path)
 		if err != nil {
 			return err
 		}
+		if rel != "." && resumeSkip(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if excluded(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if size, large := dirTooLarge(path, rel); large {
+				logOp(os.Stdout, "[SKIP] %s%c (%s, over --exclude-dir-larger-than)\n", rel, filepath.Separator, formatSize(size))
+				if dirSizes != nil {
+					atomic.AddInt64(&overallSize, -size)
+				}
+				return filepath.SkipDir
+			}
+		}
+		if d.IsDir() && skipMount(path, rel) {
+			if mountsFlag == mountsList {
+				logOp(os.Stdout, "[MOUNT] %s%c\n", rel, filepath.Separator)
+			}
+			return filepath.SkipDir
+		}
 		dstPath := filepath.Join(dstRoot, rel)
+		if !d.IsDir() {
+			if handled, err := chunkedTransfer(path, rel, dstRoot, d, onFile); handled {
+				return err
+			}
+		}
 
 		// Skip if destination already exists
-		if _, err := os.Stat(dstPath); err == nil {
+		if dstInfo, err := os.Stat(dstPath); err == nil {
+			if err := fixCase(dstPath, rel); err != nil {
+				if err := handleFailure(rel, err); err != nil {
+					return err
+				}
+				return skipEntry(d)
+			}
+			if !d.IsDir() && sameFile(path, dstPath) {
+				skipSameFile(rel)
+				return nil
+			}
+			if appendFlag && d.Type().IsRegular() {
+				if srcInfo, err := d.Info(); err == nil {
+					if offset, ok := appendOffset(path, dstPath, srcInfo, dstInfo); ok {
+						// An empty destination has nothing to append to,
+						// so it is simply replaced
+						return onFile(transferOp{src: path, dst: dstPath, rel: rel, size: srcInfo.Size() - offset, offset: offset, replace: offset == 0})
+					}
+				}
+			}
+			if (existingFlag || versionsFlag > 0) && !ignoreExistingFlag && d.Type().IsRegular() && dstInfo.Mode().IsRegular() {
+				if srcInfo, err := d.Info(); err == nil && changedFile(srcInfo, dstInfo) {
+					return onFile(transferOp{src: path, dst: dstPath, rel: rel, size: srcInfo.Size(), replace: true})
+				}
+			}
+			if onCollisionFlag == collisionRename && d.Type().IsRegular() && dstInfo.Mode().IsRegular() {
+				if srcInfo, err := d.Info(); err == nil && changedFile(srcInfo, dstInfo) {
+					if dst, ok := collisionTarget(srcInfo, dstPath); ok {
+						renamed, _ := filepath.Rel(dstRoot, dst)
+						return onFile(transferOp{src: path, dst: dst, rel: renamed, size: srcInfo.Size()})
+					}
+				}
+			}
 			if !d.IsDir() {
-				fmt.Printf("[SKIP] %s\n", rel)
-				skipped++
+				logOp(os.Stdout, "[SKIP] %s\n", rel)
+				atomic.AddInt64(&skipped, 1)
+			} else if deleteFlag && deleteTiming == deleteDuring && dstInfo.IsDir() {
+				return deleteInDir(srcRoot, dstRoot, rel)
 			}
 			return nil
 		} else if !os.IsNotExist(err) {
-			return err
+			return handleFailure(rel, err)
+		} else if existingFlag {
+			// Nothing under a missing directory can exist either
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
 
-		// Handle directories
+		// Handle directories; one that can't be created is skipped whole
 		if d.IsDir() {
-			if applyFlag {
-				return os.MkdirAll(dstPath, 0o755)
+			if err := onDir(rel, dstPath); err != nil {
+				if err := handleFailure(rel, err); err != nil {
+					return err
+				}
+				return filepath.SkipDir
 			}
 			return nil
 		}
 
-		// Skip symlinks
-		if d.Type()&os.ModeSymlink != 0 {
+		// Skip symlinks unless --links recreates them, and every file
+		// with --dirs-only
+		if dirsOnlyFlag {
 			return nil
 		}
-
-		copied++
-		if applyFlag {
-			if moveFlag {
-				return moveFile(path, dstPath, rel)
+		if d.Type()&os.ModeSymlink != 0 {
+			if !linksFlag {
+				return nil
 			}
-			return copyFile(path, dstPath, rel)
-		} else {
-			// Just list the files to be copied/moved
-			operation := "COPY"
-			if moveFlag {
-				operation = "MOVE"
+			if err := copySymlink(path, dstPath, rel, srcRoot, dstRoot); err != nil {
+				return handleFailure(rel, err)
 			}
-			fmt.Printf("[%s] %s\n", operation, rel)
+			return nil
 		}
-		return nil
+
+		op := transferOp{src: path, dst: dstPath, rel: rel}
+		if ref, done := referenceFile(rel, d); done {
+			return nil
+		} else if ref != "" {
+			op.src, op.orig = ref, path
+		}
+		if info, err := d.Info(); err == nil {
+			op.size = info.Size()
+		}
+		return onFile(op)
 	})
+}
 
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+// runTransfer copies or moves a single file, or only lists it in preview mode.
+func runTransfer(op transferOp) error {
+	if applyFlag {
+		if err := checkQuota(op); err != nil {
+			return err
+		}
+		if err := checkFreeSpace(op); err != nil {
+			return err
+		}
+	}
+	atomic.AddInt64(&copied, 1)
+	if applyFlag {
+		err := applyTransfer(op)
+		if err == nil {
+			countTransfer(op)
+			recordType(op)
+		} else if !errors.Is(err, errAborted) {
+			atomic.AddInt64(&copied, -1)
+			err = handleFailure(op.rel, err)
+		}
+		if err == nil {
+			checkpoints.note(op.rel)
+		}
+		return err
 	}
 
-	operation := "copy"
+	// Just list the files to be copied/moved
+	logTransfer(os.Stdout, op)
+	return nil
+}
+
+// printOverall reports overall progress as a percentage of the scanned
+// size, or as a running total when the scan was skipped (--no-prescan).
+func printOverall() {
+	if plainProgress() {
+		return
+	}
+	throughput.observe(time.Now())
+	spark := throughput.sparkline()
+	if spark != "" {
+		spark = "  " + spark
+	}
+	done := atomic.LoadInt64(&overallProgress)
+	if noPrescanFlag {
+		fmt.Fprintf(os.Stderr, "\rOverall: %d files, %s so far%s\n", atomic.LoadInt64(&copied), formatSize(done), spark)
+		return
+	}
+	if overallSize > 0 {
+		fmt.Fprintf(os.Stderr, "\rOverall: %d%%%s\n", percent(done, overallSize), spark)
+	}
+}
+
+// applyTransfer copies, moves or appends one file, retrying as configured.
+func applyTransfer(op transferOp) error {
+	if err := control.checkpoint(); err != nil {
+		return skipOrAbort(err, op.rel)
+	}
+	if sameFile(op.src, op.dst) {
+		// The target may have changed since the walk or plan looked at it
+		atomic.AddInt64(&copied, -1)
+		skipSameFile(op.rel)
+		return nil
+	}
+	logTransfer(os.Stderr, op)
+	if op.split {
+		return skipOrAbort(withRetries(op.rel, func(ctx context.Context) error { return splitFile(ctx, op) }), op.rel)
+	}
+	if op.join {
+		return skipOrAbort(withRetries(op.rel, func(ctx context.Context) error { return joinFile(ctx, op) }), op.rel)
+	}
+	if op.offset > 0 {
+		return skipOrAbort(withRetries(op.rel, func(ctx context.Context) error { return appendFile(ctx, op) }), op.rel)
+	}
 	if moveFlag {
-		operation = "move"
+		return skipOrAbort(withRetries(op.rel, func(ctx context.Context) error { return moveFile(ctx, op.src, op.dst, op.rel, op.replace) }), op.rel)
 	}
+	err := withRetries(op.rel, func(ctx context.Context) error { return copyFile(ctx, op.src, op.dst, op.rel, op.replace) })
+	if err == nil {
+		clearArchive(op.source())
+	}
+	return skipOrAbort(err, op.rel)
+}
 
-	if applyFlag {
-		fmt.Printf("Operation complete: %d files %sd, %d skipped\n", copied, operation, skipped)
-	} else {
-		fmt.Printf("Preview: %d files will be %sd\n", copied, operation)
+// skipOrAbort turns a user skip request into a skipped file so the walk can
+// continue; every other error, including an abort, is passed through.
+func skipOrAbort(err error, relPath string) error {
+	if !errors.Is(err, errSkipped) {
+		return err
 	}
+	atomic.AddInt64(&copied, -1)
+	atomic.AddInt64(&skipped, 1)
+	logOp(os.Stdout, "[SKIP] %s (skipped by user)\n", relPath)
+	return nil
 }
 
-func moveFile(src, dst, relPath string) error {
-	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+func moveFile(ctx context.Context, src, dst, relPath string, replace bool) error {
+	if err := ensureDir(filepath.Dir(dst)); err != nil {
 		return err
 	}
 
@@ -279,77 +1248,193 @@ func moveFile(src, dst, relPath string) error {
 		return err
 	}
 
-	fmt.Fprintf(os.Stderr, "[MOVE] %s\n", relPath)
-
-	atomic.AddInt64(&overallProgress, info.Size())
+	status.setCurrentFile(relPath)
 
-	if err := os.Rename(src, dst); err != nil {
+	if replace {
+		if err := makeWayFor(dst); err != nil {
+			return err
+		}
+	}
+	fileOps.wait()
+	err = renameFile(src, dst)
+	if isCrossDevice(err) {
+		err = moveAcrossDevices(ctx, src, dst, relPath, info, replace)
+	} else if err == nil {
+		atomic.AddInt64(&overallProgress, info.Size())
+	}
+	if err != nil {
+		return err
+	}
+	noteMovedOut(src)
+	if err := applyChmod(dst, info.Mode()); err != nil {
 		return err
 	}
 
-	// Display overall progress with animated bar after each file move
-	if overallSize > 0 {
-		pct := (atomic.LoadInt64(&overallProgress) * 100) / overallSize
-		if pct > 100 {
-			pct = 100
-		}
-		fmt.Fprintf(os.Stderr, "\rOverall: %d%%\n", pct)
+	// Display overall progress after each file move
+	if screen == nil {
+		printOverall()
 	}
 
 	return nil
 }
 
-func copyFile(src, dst, relPath string) error {
-	in, err := os.Open(src)
+func copyFile(ctx context.Context, src, dst, relPath string, replace bool) error {
+	info, err := os.Stat(src)
 	if err != nil {
 		return err
 	}
-	defer in.Close()
 
-	info, err := in.Stat()
+	status.setCurrentFile(relPath)
+
+	fileName := filepath.Base(src)
+	if screen != nil {
+		screen.setFile(relPath, info.Size())
+	}
+	progressWriter := &progressWriter{
+		fileName: fileName,
+		total:    info.Size(),
+		ctx:      ctx,
+	}
+
+	sum, err := streamFile(src, dst, info.Mode(), replace, progressWriter)
+	if err == nil {
+		err = recordCreate(dst)
+	}
+	if err == nil && sum != "" {
+		recordSum(relPath, sum)
+	}
 	if err != nil {
+		// The partial file was removed, so its bytes no longer count
+		atomic.AddInt64(&overallProgress, -atomic.LoadInt64(&progressWriter.current))
+	}
+	if errors.Is(err, errSkipped) || errors.Is(err, errAborted) {
 		return err
 	}
-
-	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+	if screen != nil {
 		return err
 	}
+	endProgressLine()
 
-	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, info.Mode())
+	// Display overall progress after each file copy
+	printOverall()
+
+	return err
+}
+
+// partSuffix marks files still being written when not copying --inplace.
+const partSuffix = ".lyphotos-part"
+
+// partPath is the temporary name dst is written under before it is renamed
+// into place, so an interrupted copy never leaves a truncated file under
+// the final name.
+func partPath(dst string) string {
+	return filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+partSuffix)
+}
+
+// streamFile copies src into a newly created dst, passing every chunk read
+// through progress. This is the I/O path shared by copies and benchmarks.
+// Unless --inplace is set the data goes to a temporary file first. When a
+// checksum feature is on, the source is hashed on the way through and its
+// checksum returned.
+func streamFile(src, dst string, mode os.FileMode, replace bool, progress io.Writer) (string, error) {
+	if err := injectFault("open", src); err != nil {
+		return "", err
+	}
+	in, err := openFiles.open(src)
 	if err != nil {
-		return err
+		return "", err
+	}
+	defer in.Close()
+	srcInfo, err := in.Stat()
+	if err != nil {
+		return "", err
 	}
-	defer out.Close()
 
-	fmt.Fprintf(os.Stderr, "[COPY] %s\n", relPath)
+	if err := ensureDir(filepath.Dir(dst)); err != nil {
+		return "", err
+	}
 
-	fileName := filepath.Base(src)
-	progressWriter := &progressWriter{
-		fileName: fileName,
-		total:    info.Size(),
+	target, flags := dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL
+	if !inplaceFlag {
+		// A leftover part file from an interrupted run is simply replaced
+		target, flags = partPath(dst), os.O_CREATE|os.O_WRONLY|os.O_TRUNC
+	} else if replace {
+		if undoLog != nil || versionsFlag > 0 {
+			if err := makeWayFor(dst); err != nil {
+				return "", err
+			}
+		} else {
+			flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		}
+	}
+	out, err := openFiles.openFile(target, flags, mode)
+	if err != nil {
+		return "", err
 	}
+	presetAttrs(src, target)
 
-	// Use TeeReader to update progress and copy file
-	reader := io.TeeReader(in, progressWriter)
-	_, err = io.Copy(out, reader)
-	fmt.Fprint(os.Stderr, "\n")
+	var reader io.Reader = in
+	var writer io.Writer = out
+	if faultHook != nil {
+		reader = faultReader{r: in, path: src}
+		writer = faultWriter{w: out, path: target}
+	}
+	writer = throttle(writer)
+	var hasher hash.Hash
+	if hashWhileCopying() {
+		hasher = newHash()
+		progress = io.MultiWriter(progress, hasher)
+	}
 
-	// Display overall progress with animated bar after each file copy
-	if overallSize > 0 {
-		pct := (atomic.LoadInt64(&overallProgress) * 100) / overallSize
-		if pct > 100 {
-			pct = 100
+	// Use TeeReader to update progress and copy file
+	_, err = copyData(writer, io.TeeReader(reader, progress))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = applyChmod(target, srcInfo.Mode())
+	}
+	if err == nil {
+		err = preserveTimes(target, srcInfo)
+	}
+	var sum string
+	if err == nil && hasher != nil {
+		sum = hex.EncodeToString(hasher.Sum(nil))
+		if verifyFlag {
+			// Check before the copy gets its final name
+			err = verifyCopy(target, sum)
 		}
-		fmt.Fprintf(os.Stderr, "\rOverall: %d%%\n", pct)
 	}
-
-	return err
+	if err == nil && target != dst {
+		if _, statErr := os.Lstat(dst); statErr == nil && !replace {
+			err = fmt.Errorf("%s: %w", dst, fs.ErrExist)
+		} else if replace {
+			clearReadonly(dst)
+			err = makeWayFor(dst)
+		}
+		if err == nil {
+			fileOps.wait()
+			err = os.Rename(target, dst)
+		}
+	}
+	if err != nil {
+		// Don't leave a partial file behind that later runs would skip
+		os.Remove(target)
+		return sum, err
+	}
+	copyFileFlags(dst, srcInfo)
+	copyAttrs(src, dst)
+	copyWinAttrs(src, dst)
+	return sum, nil
 }
 
 func handleDuplicates(dir string, apply bool) {
 	foundChanges := 0
 	
 	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() && d.Name() == undoDirName {
+			return filepath.SkipDir
+		}
 		if err != nil || d.IsDir() {
 			return nil
 		}
@@ -393,7 +1478,7 @@ func handleDuplicates(dir string, apply bool) {
 			fmt.Printf("%s <-> %s (Size: %d bytes)\n", relOriginal, relDuplicate, info1.Size())
 			
 			if apply {
-				if err := os.Remove(path); err != nil {
+				if err := removeFile(path); err != nil {
 					fmt.Fprintf(os.Stderr, "Error removing %s: %v\n", path, err)
 				} else {
 					fmt.Printf("  Removed: %s\n", path)
@@ -406,7 +1491,7 @@ func handleDuplicates(dir string, apply bool) {
 	
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		exit(1)
 	}
 	
 	if foundChanges == 0 {
@@ -426,6 +1511,9 @@ func handleXMPRenaming(dir string, apply bool, orphaned bool) {
 	
 	// Scan for XMP files and determine if they need renaming
 	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() && d.Name() == undoDirName {
+			return filepath.SkipDir
+		}
 		if err != nil || d.IsDir() {
 			return nil
 		}
@@ -536,7 +1624,7 @@ func handleXMPRenaming(dir string, apply bool, orphaned bool) {
 				relPath, _ := filepath.Rel(dir, path)
 				fmt.Printf("[REMOVE] %s (orphaned XMP, no base file exists)\n", relPath)
 				if apply {
-					if err := os.Remove(path); err != nil {
+					if err := removeFile(path); err != nil {
 						fmt.Fprintf(os.Stderr, "Error removing %s: %v\n", path, err)
 					}
 				}
@@ -556,7 +1644,7 @@ func handleXMPRenaming(dir string, apply bool, orphaned bool) {
 				relPath, _ := filepath.Rel(dir, path)
 				fmt.Printf("[REMOVE] %s (orphaned XMP, no base file exists)\n", relPath)
 				if apply {
-					if err := os.Remove(path); err != nil {
+					if err := removeFile(path); err != nil {
 						fmt.Fprintf(os.Stderr, "Error removing %s: %v\n", path, err)
 					}
 				}
@@ -589,7 +1677,7 @@ func handleXMPRenaming(dir string, apply bool, orphaned bool) {
 					// Base image doesn't exist, the destination XMP is orphaned, remove it
 					if orphaned {
 						if apply {
-							if err := os.Remove(correctXMPPath); err != nil {
+							if err := removeFile(correctXMPPath); err != nil {
 								fmt.Fprintf(os.Stderr, "Error removing %s: %v\n", correctXMPPath, err)
 							} else {
 								relDestPath, _ := filepath.Rel(dir, correctXMPPath)
@@ -623,13 +1711,14 @@ func handleXMPRenaming(dir string, apply bool, orphaned bool) {
 	
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		exit(1)
 	}
 	
-	// Apply changes if requested
+	// Apply changes if requested, in path order so the output is stable
 	if apply {
-		for src, dst := range xmpFiles {
-			if err := os.Rename(src, dst); err != nil {
+		for _, src := range slices.Sorted(maps.Keys(xmpFiles)) {
+			dst := xmpFiles[src]
+			if err := renameFile(src, dst); err != nil {
 				fmt.Fprintf(os.Stderr, "Error renaming %s: %v\n", src, err)
 			} else {
 				fmt.Printf("  Renamed: %s to %s\n", src, dst)
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// systemMounts returns the mount points listed in /proc/self/mountinfo.
+func systemMounts() map[string]bool {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	mounts := make(map[string]bool)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// ID, parent ID, major:minor, root, mount point, ...
+		fields := strings.Fields(sc.Text())
+		if len(fields) > 4 {
+			mounts[unescapeMountPath(fields[4])] = true
+		}
+	}
+	return mounts
+}
+
+// unescapeMountPath decodes the octal escapes (\040 for a space) the
+// kernel uses in mount paths.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// systemMounts has no mount table to read here; mounts are found by
+// device ID alone.
+func systemMounts() map[string]bool {
+	return nil
+}
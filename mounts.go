@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Policies accepted by --mounts for mount points found under the source.
+const (
+	mountsInclude = "include"
+	mountsSkip    = "skip"
+	mountsList    = "list"
+)
+
+// mountsFlag says what to do with a mount point under the source: copy
+// through it, leave it out, or list it without descending.
+var mountsFlag = mountsInclude
+
+var (
+	bindMountsOnce sync.Once
+	bindMounts     map[string]bool
+)
+
+func validateMounts(policy string) error {
+	switch policy {
+	case mountsInclude, mountsSkip, mountsList:
+		return nil
+	}
+	return fmt.Errorf("invalid --mounts %q (want include, skip or list)", policy)
+}
+
+// isMountPoint reports whether the directory at path is a mount point:
+// on another device than its parent, or in the system mount table, which
+// is how bind mounts of the same filesystem are found.
+func isMountPoint(path string) bool {
+	bindMountsOnce.Do(func() { bindMounts = systemMounts() })
+	if abs, err := filepath.Abs(path); err == nil && bindMounts[abs] {
+		return true
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return false
+	}
+	parent, err := os.Lstat(filepath.Dir(path))
+	if err != nil {
+		return false
+	}
+	dev, ok := deviceID(info)
+	parentDev, parentOK := deviceID(parent)
+	return ok && parentOK && dev != parentDev
+}
+
+// skipMount reports whether --mounts leaves the source directory rel out
+// of the walk.
+func skipMount(path, rel string) bool {
+	return mountsFlag != mountsInclude && rel != "." && isMountPoint(path)
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+// deviceID is unsupported here, so only the mount table finds mounts.
+func deviceID(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,6 @@
+package main
+
+import "syscall"
+
+// oNoatime keeps reads from updating a file's access time.
+const oNoatime = syscall.O_NOATIME
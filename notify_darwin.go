@@ -0,0 +1,14 @@
+//go:build darwin
+
+package main
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// desktopNotify posts a Notification Center banner through osascript.
+func desktopNotify(title, message string) error {
+	script := "display notification " + strconv.Quote(message) + " with title " + strconv.Quote(title)
+	return exec.Command("osascript", "-e", script).Run()
+}
@@ -0,0 +1,9 @@
+//go:build !darwin && !linux && !freebsd && !netbsd && !openbsd && !windows
+
+package main
+
+import "errors"
+
+func desktopNotify(title, message string) error {
+	return errors.New("desktop notifications are not supported on this platform")
+}
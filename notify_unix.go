@@ -0,0 +1,20 @@
+//go:build linux || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"os/exec"
+)
+
+// desktopNotify sends a freedesktop notification over the session DBus,
+// preferring notify-send and falling back to gdbus.
+func desktopNotify(title, message string) error {
+	if path, err := exec.LookPath("notify-send"); err == nil {
+		return exec.Command(path, "--app-name=lyphotos", title, message).Run()
+	}
+	return exec.Command("gdbus", "call", "--session",
+		"--dest", "org.freedesktop.Notifications",
+		"--object-path", "/org/freedesktop/Notifications",
+		"--method", "org.freedesktop.Notifications.Notify",
+		"lyphotos", "0", "", title, message, "[]", "{}", "-1").Run()
+}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// desktopNotify shows a toast notification through the WinRT API from
+// PowerShell, which is available on every supported Windows version.
+func desktopNotify(title, message string) error {
+	quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+	script := `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $xml.GetElementsByTagName('text')
+$text.Item(0).AppendChild($xml.CreateTextNode(` + quote(title) + `)) > $null
+$text.Item(1).AppendChild($xml.CreateTextNode(` + quote(message) + `)) > $null
+$toast = [Windows.UI.Notifications.ToastNotification]::new($xml)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('lyphotos').Show($toast)`
+	return exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script).Run()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// transferOp is a single file waiting to be copied or moved.
+type transferOp struct {
+	src     string
+	dst     string
+	rel     string
+	size    int64
+	offset  int64 // --append: bytes already present at dst
+	replace bool  // --existing: dst is an outdated copy to overwrite
+	split   bool  // --split-size: store as chunks at dst
+	join    bool  // src is a chunk manifest to reassemble at dst
+	// orig is the source file when src is a --copy-dest reference
+	// standing in for it.
+	orig string
+}
+
+// source is the source file op transfers, even when it reads a
+// --copy-dest reference instead.
+func (op transferOp) source() string {
+	if op.orig != "" {
+		return op.orig
+	}
+	return op.src
+}
+
+// Transfer orders accepted by --order.
+const (
+	orderDiscovery     = "discovery"
+	orderAlpha         = "alpha"
+	orderLargestFirst  = "largest-first"
+	orderSmallestFirst = "smallest-first"
+)
+
+func validateOrder(order string) error {
+	switch order {
+	case orderDiscovery, orderAlpha, orderLargestFirst, orderSmallestFirst:
+		return nil
+	}
+	return fmt.Errorf("invalid --order %q (want largest-first, smallest-first, alpha or discovery)", order)
+}
+
+// sortTransfers reorders ops in place. Ties keep discovery order so runs
+// stay deterministic.
+func sortTransfers(ops []transferOp, order string) {
+	switch order {
+	case orderAlpha:
+		sort.SliceStable(ops, func(i, j int) bool { return ops[i].rel < ops[j].rel })
+	case orderLargestFirst:
+		sort.SliceStable(ops, func(i, j int) bool { return ops[i].size > ops[j].size })
+	case orderSmallestFirst:
+		sort.SliceStable(ops, func(i, j int) bool { return ops[i].size < ops[j].size })
+	}
+}
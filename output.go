@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// Values accepted by --output.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// outputFlag selects what a copy/move prints on stdout: the usual lines,
+// or (json) nothing but one summary document at the end.
+var outputFlag = outputText
+
+// jsonOut is the real stdout while --output=json sends everything else
+// to stderr.
+var jsonOut *os.File
+
+// runSummary is the document --output=json prints when a run ends.
+type runSummary struct {
+	Operation        string    `json:"operation"`
+	Source           string    `json:"source"`
+	Target           string    `json:"target"`
+	State            string    `json:"state"`
+	Preview          bool      `json:"preview"`
+	Transferred      int64     `json:"transferred"`
+	Skipped          int64     `json:"skipped"`
+	Failed           int64     `json:"failed"`
+	Deleted          int64     `json:"deleted"`
+	DanglingLinks    int64     `json:"danglingLinks"`
+	BytesTransferred int64     `json:"bytesTransferred"`
+	BytesTotal       int64     `json:"bytesTotal"`
+	Duration         float64   `json:"durationSeconds"`
+	Failures         []failure `json:"failures"`
+}
+
+// useOutputFormat checks --output and, for json, points os.Stdout at
+// stderr so every human-readable line ends up there.
+func useOutputFormat() error {
+	switch outputFlag {
+	case outputText:
+		return nil
+	case outputJSON:
+		jsonOut = os.Stdout
+		os.Stdout = os.Stderr
+		return nil
+	}
+	return fmt.Errorf("invalid --output %q (want text or json)", outputFlag)
+}
+
+// writeRunSummary prints the final document for --output=json.
+func writeRunSummary(snap statusSnapshot) {
+	if jsonOut == nil {
+		return
+	}
+	s := runSummary{
+		Operation:        snap.Operation,
+		Source:           snap.Source,
+		Target:           snap.Target,
+		State:            snap.State,
+		Preview:          !applyFlag,
+		Transferred:      snap.Copied,
+		Skipped:          snap.Skipped,
+		Failed:           atomic.LoadInt64(&failed),
+		Deleted:          atomic.LoadInt64(&deleted),
+		DanglingLinks:    atomic.LoadInt64(&danglingLinks),
+		BytesTransferred: atomic.LoadInt64(&transferredBytes),
+		BytesTotal:       snap.BytesTotal,
+		Duration:         snap.Elapsed,
+		Failures:         snap.Failures,
+	}
+	if s.Failures == nil {
+		s.Failures = []failure{}
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot write the JSON summary: %v\n", err)
+		return
+	}
+	jsonOut.Write(append(data, '\n'))
+}
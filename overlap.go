@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// checkOverlap refuses a source and target that are the same directory or
+// nested in one another; the walk would otherwise copy its own output.
+// Both are compared with symlinks resolved.
+func checkOverlap(srcRoot, dstRoot string) error {
+	src, err := resolvePath(srcRoot)
+	if err != nil {
+		return err
+	}
+	dst, err := resolvePath(dstRoot)
+	if err != nil {
+		return err
+	}
+	switch {
+	case src == dst:
+		return fmt.Errorf("source and target are the same directory: %s", src)
+	case within(dst, src):
+		return fmt.Errorf("target %s is inside source %s", dstRoot, srcRoot)
+	case within(src, dst):
+		return fmt.Errorf("source %s is inside target %s", srcRoot, dstRoot)
+	}
+	return nil
+}
+
+// resolvePath makes path absolute with symlinks resolved.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// within reports whether path lies below dir.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// sameFile reports whether src and dst are the same file on disk, such
+// as through a hard link or a symlinked directory. Writing one over the
+// other would destroy it.
+func sameFile(src, dst string) bool {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return false
+	}
+	dstInfo, err := os.Stat(dst)
+	return err == nil && os.SameFile(srcInfo, dstInfo)
+}
+
+// skipSameFile logs and counts a file skipped because src and dst are
+// one and the same.
+func skipSameFile(rel string) {
+	logOp(os.Stdout, "[SKIP] %s (source and target are the same file)\n", rel)
+	atomic.AddInt64(&skipped, 1)
+}
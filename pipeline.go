@@ -0,0 +1,50 @@
+package main
+
+import "errors"
+
+// transferQueueSize bounds how many files the walk may discover ahead of
+// the transfers. Together with WalkDir only holding one directory listing
+// per level, it keeps memory flat however many files the source holds.
+const transferQueueSize = 256
+
+var errPipelineStopped = errors.New("transfer pipeline stopped")
+
+// pipeTransfers runs walkTransfers in its own goroutine and feeds each file
+// through a bounded channel to run, so copying starts straight away and
+// nothing about already transferred files is kept. Directories are still
+// created by the walk, before any file inside them is queued. The first
+// error from either side stops both.
+//
+// Only discovery order can stream; the other --order modes have to see
+// every file before the first one is transferred.
+func pipeTransfers(srcRoot, dstRoot string, onDir func(rel, dst string) error, run func(op transferOp) error) error {
+	ops := make(chan transferOp, transferQueueSize)
+	stop := make(chan struct{})
+	walkErr := make(chan error, 1)
+
+	go func() {
+		defer close(ops)
+		walkErr <- walkTransfers(srcRoot, dstRoot, onDir, func(op transferOp) error {
+			select {
+			case ops <- op:
+				return nil
+			case <-stop:
+				return errPipelineStopped
+			}
+		})
+	}()
+
+	var err error
+	for op := range ops {
+		if err != nil {
+			continue // drain until the walk notices stop
+		}
+		if err = run(op); err != nil {
+			close(stop)
+		}
+	}
+	if werr := <-walkErr; err == nil {
+		err = werr
+	}
+	return err
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// transferPlan is the reviewable list of operations written by "plan" and
+// executed verbatim by "apply". Mode is "copy" or "move"; SplitSize and
+// Versions carry --split-size and --versions, which change how the
+// operations are carried out.
+type transferPlan struct {
+	Version     int         `json:"version"`
+	Created     time.Time   `json:"created"`
+	Mode        string      `json:"mode"`
+	Source      string      `json:"source"`
+	Target      string      `json:"target"`
+	SplitSize   int64       `json:"split_size,omitempty"`
+	Versions    int         `json:"versions,omitempty"`
+	Directories []string    `json:"directories"`
+	Operations  []plannedOp `json:"operations"`
+}
+
+// plannedOp is one operation on the target file Path. Action is "copy" or
+// "move" for a new file, "update" for a file that exists and differs,
+// "append", "split", "join" or "delete". Src is the file read, which is not
+// always Path under the source (a --copy-dest reference, a chunk manifest,
+// a collision-renamed target), Orig the source file a --copy-dest
+// reference stands in for, and Dst the file written or deleted. Size
+// is the number of bytes to transfer, or for a delete the bytes removed;
+// an append starts at Offset. Replace marks a split or join that redoes a
+// changed file stored by an earlier run.
+type plannedOp struct {
+	Action  string `json:"action"`
+	Path    string `json:"path"`
+	Src     string `json:"src,omitempty"`
+	Orig    string `json:"orig,omitempty"`
+	Dst     string `json:"dst"`
+	Size    int64  `json:"size"`
+	Offset  int64  `json:"offset,omitempty"`
+	Replace bool   `json:"replace,omitempty"`
+}
+
+const planVersion = 2
+
+// runPlan computes the operations a copy/move would perform and writes
+// them as JSON without touching either tree.
+func runPlan(args []string) {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	out := fs.String("o", "plan.json", "file to write the plan to")
+	move := fs.Bool("move", moveFlag, "plan a move instead of a copy")
+	del := fs.Bool("delete", deleteFlag, "also plan deleting target files that are not in the source")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s plan [--move] [--delete] [-o plan.json] <source> <target>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseSubcommand(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		exit(1)
+	}
+	copyFlag, moveFlag, deleteFlag = !*move, *move, *del
+	if err := validateOrder(orderFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := resolveDeleteTiming(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := checkPlannable(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+
+	srcRoot := filepath.Clean(fs.Arg(0))
+	dstRoot := filepath.Clean(fs.Arg(1))
+	for _, dir := range []string{srcRoot, dstRoot} {
+		if _, err := os.Stat(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+	}
+	if err := checkOverlap(srcRoot, dstRoot); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := resolveRefDirs(dstRoot); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+
+	action := "copy"
+	if *move {
+		action = "move"
+	}
+	plan := transferPlan{Version: planVersion, Created: time.Now().UTC(), Mode: action, Source: srcRoot, Target: dstRoot, SplitSize: splitSize, Versions: versionsFlag}
+	var ops []transferOp
+	err := walkTransfers(srcRoot, dstRoot, func(rel, dst string) error {
+		if rel != "." && !pruneEmptyDirsFlag {
+			plan.Directories = append(plan.Directories, rel)
+		}
+		return nil
+	}, func(op transferOp) error {
+		ops = append(ops, op)
+		return nil
+	})
+	var deletions []plannedOp
+	if err == nil && deleteFlag {
+		deletions, err = plannedDeletions(srcRoot, dstRoot)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+
+	sortTransfers(ops, orderFlag)
+	if deleteTiming == deleteBefore {
+		plan.Operations = append(plan.Operations, deletions...)
+	}
+	var total, updates int64
+	for _, op := range ops {
+		p := plannedOp{Action: action, Path: op.rel, Src: op.src, Orig: op.orig, Dst: op.dst, Size: op.size}
+		switch {
+		case op.offset > 0:
+			p.Action, p.Offset = "append", op.offset
+		case op.split:
+			p.Action, p.Replace = "split", op.replace
+		case op.join:
+			p.Action, p.Replace = "join", op.replace
+		case op.replace:
+			p.Action = "update"
+		}
+		if op.replace {
+			updates++
+		}
+		plan.Operations = append(plan.Operations, p)
+		total += op.size
+	}
+	if deleteTiming != deleteBefore {
+		plan.Operations = append(plan.Operations, deletions...)
+	}
+	var freed int64
+	for _, p := range deletions {
+		freed += p.Size
+	}
+
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := os.WriteFile(*out, append(data, '\n'), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	fmt.Printf("Plan written to %s: %d files to %s (%s, %d of them updates), %d entries to delete (%s), %d directories to create, %d skipped\n",
+		*out, len(ops), action, formatSize(total), updates, len(deletions), formatSize(freed), len(plan.Directories), skipped)
+}
+
+// plannedDeletions lists what a --delete run would remove from dstRoot,
+// whole directories at a time, with the bytes each frees.
+func plannedDeletions(srcRoot, dstRoot string) ([]plannedOp, error) {
+	var ops []plannedOp
+	err := walkExtraneous(srcRoot, dstRoot, func(rel, path string, d fs.DirEntry) {
+		_, size := treeSize(path)
+		ops = append(ops, plannedOp{Action: "delete", Path: rel, Dst: path, Size: size})
+	})
+	return ops, err
+}
+
+// checkPlannable refuses the options whose effect a plan can't record:
+// they act during the walk itself rather than through the operations.
+func checkPlannable() error {
+	if err := parseSplitSize(); err != nil {
+		return err
+	}
+	switch {
+	case linksFlag:
+		return errors.New("plan cannot be used with --links")
+	case deleteFlag && deleteTiming == deleteDuring:
+		return errors.New("plan cannot be used with --delete-during; use --delete-before or --delete-after")
+	case deleteFlag && moveFlag:
+		return errors.New("--delete can only be used with --copy")
+	case deleteFlag && onCollisionFlag == collisionRename:
+		return errors.New("--delete cannot be used with --on-collision=rename, which keeps every version")
+	case appendFlag && moveFlag:
+		return errors.New("--append can only be used with --copy")
+	}
+	return nil
+}
+
+// runApplyPlan executes exactly the operations in a plan file. Files that
+// appeared at the destination since planning are skipped; sources that
+// vanished or changed size abort the run.
+func runApplyPlan(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s apply <plan.json>\n", os.Args[0])
+		exit(1)
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	var plan transferPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid plan %s: %v\n", args[0], err)
+		exit(1)
+	}
+	if plan.Version != planVersion {
+		fmt.Fprintf(os.Stderr, "Error: unsupported plan version %d\n", plan.Version)
+		exit(1)
+	}
+	if plan.Mode != "copy" && plan.Mode != "move" {
+		fmt.Fprintf(os.Stderr, "Error: unknown plan mode %q\n", plan.Mode)
+		exit(1)
+	}
+
+	move := plan.Mode == "move"
+	for _, op := range plan.Operations {
+		switch op.Action {
+		case "copy", "move":
+			if op.Action != plan.Mode {
+				fmt.Fprintf(os.Stderr, "Error: plan mixes copy and move operations\n")
+				exit(1)
+			}
+		case "update", "append", "split", "join":
+		case "delete":
+			deleteFlag = true
+			continue
+		default:
+			fmt.Fprintf(os.Stderr, "Error: unknown action %q for %s\n", op.Action, op.Path)
+			exit(1)
+		}
+		atomic.AddInt64(&overallSize, op.Size)
+	}
+	copyFlag, moveFlag, applyFlag = !move, move, true
+	splitSize, versionsFlag = plan.SplitSize, plan.Versions
+	if err := checkOverlap(plan.Source, plan.Target); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+
+	operation := beginTransfers(plan.Source, plan.Target)
+	startUndo(plan.Target)
+	if tuiFlag {
+		screen = startTUI(fmt.Sprintf("lyphotos apply %s  %s -> %s", args[0], plan.Source, plan.Target))
+	}
+
+	err = applyPlan(plan)
+	if err == nil {
+		err = finishTransfers(plan.Source, plan.Target)
+	}
+	endTransfers(plan.Source, operation, err)
+}
+
+func applyPlan(plan transferPlan) error {
+	for _, dir := range plan.Directories {
+		if err := makeDir(filepath.Join(plan.Target, dir)); err != nil {
+			return err
+		}
+	}
+	transferred := false
+	for _, p := range plan.Operations {
+		if p.Action == "delete" {
+			// Deleting after a partial copy could lose the only good version
+			if n := atomic.LoadInt64(&failed); transferred && n > 0 {
+				logOp(os.Stdout, "[SKIP] %s (deletion skipped: %d transfers failed)\n", p.Path, n)
+				continue
+			}
+			if err := applyPlannedDelete(plan, p); err != nil {
+				return err
+			}
+			continue
+		}
+		transferred = true
+
+		op := transferOp{
+			src:     p.Src,
+			orig:    p.Orig,
+			dst:     p.Dst,
+			rel:     p.Path,
+			size:    p.Size,
+			offset:  p.Offset,
+			replace: p.Action == "update" || p.Replace,
+			split:   p.Action == "split",
+			join:    p.Action == "join",
+		}
+		if err := checkPlannedSource(p); err != nil {
+			return err
+		}
+		switch {
+		case p.Action == "append" || op.replace:
+			// appendFile checks the destination length is unchanged, and
+			// an update replaces whatever is there
+		default:
+			dst := op.dst
+			if op.split {
+				dst += chunkManifestSuffix
+			}
+			if _, err := os.Lstat(dst); err == nil {
+				logOp(os.Stdout, "[SKIP] %s (destination appeared after planning)\n", p.Path)
+				atomic.AddInt64(&skipped, 1)
+				continue
+			}
+		}
+		if err := runTransfer(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPlannedSource makes sure the file a planned transfer reads still
+// has the size the plan was made with.
+func checkPlannedSource(p plannedOp) error {
+	if p.Action == "join" {
+		m, err := readChunkManifest(p.Src)
+		if err != nil {
+			return fmt.Errorf("source changed since plan was made: %w", err)
+		}
+		if m.Size != p.Size {
+			return fmt.Errorf("source changed since plan was made: %s is now %d bytes, planned %d", p.Path, m.Size, p.Size)
+		}
+		return nil
+	}
+	info, err := os.Lstat(p.Src)
+	if err != nil {
+		return fmt.Errorf("source changed since plan was made: %w", err)
+	}
+	if info.Size() != p.Offset+p.Size {
+		return fmt.Errorf("source changed since plan was made: %s is now %d bytes, planned %d", p.Path, info.Size(), p.Offset+p.Size)
+	}
+	return nil
+}
+
+// applyPlannedDelete removes a target entry the plan found extraneous.
+// One that is gone already is skipped; one the source has gained since
+// planning aborts the run rather than deleting it.
+func applyPlannedDelete(plan transferPlan, p plannedOp) error {
+	if _, err := os.Lstat(filepath.Join(plan.Source, p.Path)); err == nil {
+		return fmt.Errorf("source changed since plan was made: %s now exists", p.Path)
+	}
+	info, err := os.Lstat(p.Dst)
+	if os.IsNotExist(err) {
+		logOp(os.Stdout, "[SKIP] %s (already deleted)\n", p.Path)
+		atomic.AddInt64(&skipped, 1)
+		return nil
+	}
+	if err != nil {
+		return handleFailure(p.Path, err)
+	}
+	return deleteEntry(p.Dst, p.Path, info.IsDir())
+}
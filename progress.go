@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Values accepted by --progress.
+const (
+	progressBar   = "bar"
+	progressPlain = "plain-periodic"
+)
+
+// plainProgressInterval is the default period of --progress=plain-periodic
+// when --progress-interval isn't raised above its redraw default.
+const plainProgressInterval = 10 * time.Second
+
+// progressFlag selects the animated per-file bar, or a plain status line
+// every --progress-interval for screen readers and dumb consoles.
+var progressFlag = progressBar
+
+// plainProgressDone stops the periodic lines once the run is over.
+var plainProgressDone chan struct{}
+
+func validateProgress(mode string) error {
+	switch mode {
+	case progressBar, progressPlain:
+		return nil
+	}
+	return fmt.Errorf("invalid --progress %q (want bar or plain-periodic)", mode)
+}
+
+// plainProgress reports whether animated output is replaced by periodic
+// plain lines.
+func plainProgress() bool {
+	return progressFlag == progressPlain
+}
+
+// startPlainProgress prints "X% done, Y MB/s, ETA Z" to stderr every
+// interval until stopPlainProgress.
+func startPlainProgress(interval time.Duration) {
+	plainProgressDone = make(chan struct{})
+	done := plainProgressDone
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		started := time.Now()
+		last, lastTime := atomic.LoadInt64(&overallProgress), started
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				current := atomic.LoadInt64(&overallProgress)
+				rate := float64(current-last) / now.Sub(lastTime).Seconds()
+				last, lastTime = current, now
+				fmt.Fprintln(os.Stderr, plainProgressLine(current, atomic.LoadInt64(&overallSize), rate, now.Sub(started)))
+			}
+		}
+	}()
+}
+
+// endProgressLine moves past a finished file's progress bar.
+func endProgressLine() {
+	if screen == nil && !plainProgress() {
+		fmt.Fprint(os.Stderr, "\n")
+	}
+}
+
+func stopPlainProgress() {
+	if plainProgressDone != nil {
+		close(plainProgressDone)
+		plainProgressDone = nil
+	}
+}
+
+// plainProgressLine describes progress in words; the ETA assumes the
+// average rate so far holds.
+func plainProgressLine(done, total int64, rate float64, elapsed time.Duration) string {
+	speed := fmt.Sprintf("%.1f MB/s", rate/1024/1024)
+	if total <= 0 || noPrescanFlag {
+		return fmt.Sprintf("%d files, %s done, %s", atomic.LoadInt64(&copied), formatSize(done), speed)
+	}
+	eta := "unknown"
+	if done > 0 {
+		remaining := time.Duration(float64(elapsed) * float64(total-done) / float64(done))
+		eta = remaining.Round(time.Second).String()
+	}
+	return fmt.Sprintf("%d%% done, %s, ETA %s", percent(done, total), speed, eta)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// --max-transfer and --max-files end a run cleanly once it has moved that
+// much, for metered connections and migrations done in stages. Finished
+// files stay in place, so running again carries on where it stopped.
+var (
+	maxTransferFlag string
+	maxTransfer     int64
+	maxFilesFlag    int64
+)
+
+var errQuotaReached = errors.New("transfer limit reached")
+
+// Data and files transferred so far, for the quota
+var transferredBytes, transferredFiles int64
+
+func parseQuota() error {
+	if maxTransferFlag != "" {
+		n, err := parseSize(maxTransferFlag)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid --max-transfer %q", maxTransferFlag)
+		}
+		maxTransfer = n
+	}
+	if maxFilesFlag < 0 {
+		return fmt.Errorf("--max-files must not be negative")
+	}
+	return nil
+}
+
+// checkQuota returns errQuotaReached if transferring op would go over
+// --max-files or --max-transfer.
+func checkQuota(op transferOp) error {
+	if maxFilesFlag > 0 && atomic.LoadInt64(&transferredFiles) >= maxFilesFlag {
+		return errQuotaReached
+	}
+	if maxTransfer > 0 && atomic.LoadInt64(&transferredBytes)+op.size > maxTransfer {
+		return errQuotaReached
+	}
+	return nil
+}
+
+// countTransfer adds a finished transfer to the quota.
+func countTransfer(op transferOp) {
+	atomic.AddInt64(&transferredFiles, 1)
+	atomic.AddInt64(&transferredBytes, op.size)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// opRateLimiter spaces metadata operations (opens, creates, renames) so no
+// more than a fixed number happen per second. A zero rate disables it.
+type opRateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// fileOps throttles file operations for --max-iops.
+var fileOps = newOpRateLimiter(0)
+
+func newOpRateLimiter(perSecond int) *opRateLimiter {
+	l := &opRateLimiter{}
+	if perSecond > 0 {
+		l.interval = time.Second / time.Duration(perSecond)
+	}
+	return l
+}
+
+// wait blocks until the next operation is allowed.
+func (l *opRateLimiter) wait() {
+	if l.interval == 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
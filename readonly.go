@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// assertSourceReadonlyFlag guarantees the source is only ever read: modes
+// that change it are refused, and files are opened read-only without
+// updating their access times where the platform allows.
+var assertSourceReadonlyFlag bool
+
+// checkSourceReadonly refuses the operation modes that write to the
+// source under --assert-source-readonly.
+func checkSourceReadonly() error {
+	if !assertSourceReadonlyFlag {
+		return nil
+	}
+	if moveFlag {
+		return errors.New("--assert-source-readonly cannot be used with --move, which removes source files")
+	}
+	if clearArchiveFlag {
+		return errors.New("--assert-source-readonly cannot be used with --clear-archive, which changes source attributes")
+	}
+	return nil
+}
+
+// openReadonly opens name for reading. Under --assert-source-readonly it
+// asks not to touch the access time either, which only the owner may.
+func openReadonly(l *fdLimiter, name string) (*limitedFile, error) {
+	if assertSourceReadonlyFlag && oNoatime != 0 {
+		f, err := l.openFile(name, os.O_RDONLY|oNoatime, 0)
+		if !errors.Is(err, fs.ErrPermission) {
+			return f, err
+		}
+	}
+	return l.openFile(name, os.O_RDONLY, 0)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// refDir is a --compare-dest or --copy-dest reference directory. A source
+// file that is missing from the target but found unchanged here is left
+// out (compare) or copied from the reference instead of the source (copy).
+type refDir struct {
+	path string
+	copy bool
+}
+
+// refDirs are checked in the order they were given.
+var refDirs []refDir
+
+// refDirFlag adds a reference directory; both flags are repeatable.
+type refDirFlag struct {
+	copy bool
+}
+
+func (refDirFlag) String() string { return "" }
+
+func (refDirFlag) repeatable() {}
+
+func (f refDirFlag) Set(v string) error {
+	if v == "" {
+		return errors.New("empty directory")
+	}
+	refDirs = append(refDirs, refDir{path: v, copy: f.copy})
+	return nil
+}
+
+// resolveRefDirs makes relative reference directories relative to the
+// target, as rsync does, and checks that they exist.
+func resolveRefDirs(dstRoot string) error {
+	if len(refDirs) > 0 && moveFlag {
+		return errors.New("--compare-dest and --copy-dest can only be used with --copy")
+	}
+	for i, ref := range refDirs {
+		if !filepath.IsAbs(ref.path) {
+			ref.path = filepath.Join(dstRoot, ref.path)
+		}
+		ref.path = filepath.Clean(ref.path)
+		info, err := os.Stat(ref.path)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", ref.path)
+		}
+		refDirs[i] = ref
+	}
+	return nil
+}
+
+// referenceFile looks rel up in the reference directories. It reports
+// true when the file was dealt with by skipping it, and otherwise returns
+// the reference copy to transfer from, or "" to use the source.
+func referenceFile(rel string, d fs.DirEntry) (src string, done bool) {
+	if len(refDirs) == 0 || !d.Type().IsRegular() {
+		return "", false
+	}
+	srcInfo, err := d.Info()
+	if err != nil {
+		return "", false
+	}
+	for _, ref := range refDirs {
+		path := filepath.Join(ref.path, rel)
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || changedFile(srcInfo, info) {
+			continue
+		}
+		if ref.copy {
+			return path, false
+		}
+		logOp(os.Stdout, "[SKIP] %s (unchanged in %s)\n", rel, ref.path)
+		atomic.AddInt64(&skipped, 1)
+		return "", true
+	}
+	return "", false
+}
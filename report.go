@@ -0,0 +1,172 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+type sizeBucket struct {
+	limit int64
+	label string
+}
+
+// sizeBuckets are the upper bounds of the file size histogram; the last
+// bucket takes everything from 4 GiB up.
+var sizeBuckets = []sizeBucket{
+	{4 << 10, "< 4 KiB"},
+	{64 << 10, "4-64 KiB"},
+	{1 << 20, "64 KiB-1 MiB"},
+	{16 << 20, "1-16 MiB"},
+	{256 << 20, "16-256 MiB"},
+	{4 << 30, "256 MiB-4 GiB"},
+	{-1, ">= 4 GiB"},
+}
+
+// treeReport is what the report subcommand prints, as text or JSON.
+type treeReport struct {
+	Root      string         `json:"root"`
+	Files     int64          `json:"files"`
+	Bytes     int64          `json:"bytes"`
+	Dirs      []reportDir    `json:"directories"`
+	Histogram []reportBucket `json:"histogram"`
+	Largest   []reportFile   `json:"largest"`
+}
+
+type reportDir struct {
+	Path  string `json:"path"`
+	Files int64  `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+type reportBucket struct {
+	Label string `json:"label"`
+	Files int64  `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+type reportFile struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// runReport scans a tree with the same walk, filters and scan cache as a
+// copy would and summarises it: size by directory, a size histogram and
+// the largest files.
+func runReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	top := fs.Int("top", 10, "how many of the largest files and directories to list")
+	depth := fs.Int("depth", 1, "directory depth to total sizes at")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s report [--json] [--top 10] [--depth 1] <dir>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseSubcommand(fs, args)
+	if fs.NArg() != 1 || *depth < 1 {
+		fs.Usage()
+		exit(1)
+	}
+	root := filepath.Clean(fs.Arg(0))
+	if info, err := os.Stat(root); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	} else if !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "Error: %s is not a directory\n", root)
+		exit(1)
+	}
+
+	r := treeReport{Root: root}
+	for _, b := range sizeBuckets {
+		r.Histogram = append(r.Histogram, reportBucket{Label: b.label})
+	}
+	dirs := map[string]*reportDir{}
+	scanVisit = func(rel string, info os.FileInfo) {
+		size := info.Size()
+		r.Files++
+		i := slices.IndexFunc(sizeBuckets, func(b sizeBucket) bool { return b.limit < 0 || size < b.limit })
+		r.Histogram[i].Files++
+		r.Histogram[i].Bytes += size
+
+		dir := reportDirAt(rel, *depth)
+		d := dirs[dir]
+		if d == nil {
+			d = &reportDir{Path: dir}
+			dirs[dir] = d
+		}
+		d.Files++
+		d.Bytes += size
+		r.Largest = append(r.Largest, reportFile{Path: rel, Bytes: size})
+	}
+	scanSource(root)
+	r.Bytes = atomic.LoadInt64(&overallSize)
+
+	slices.SortFunc(r.Largest, func(a, b reportFile) int {
+		return cmp.Or(cmp.Compare(b.Bytes, a.Bytes), strings.Compare(a.Path, b.Path))
+	})
+	r.Largest = r.Largest[:min(max(*top, 0), len(r.Largest))]
+	for _, d := range dirs {
+		r.Dirs = append(r.Dirs, *d)
+	}
+	slices.SortFunc(r.Dirs, func(a, b reportDir) int {
+		return cmp.Or(cmp.Compare(b.Bytes, a.Bytes), strings.Compare(a.Path, b.Path))
+	})
+	r.Dirs = r.Dirs[:min(max(*top, 0), len(r.Dirs))]
+
+	if *asJSON {
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+		fmt.Println(string(data))
+		return
+	}
+	r.print()
+}
+
+// reportDirAt is the directory holding rel, cut to depth levels; files
+// directly under the root are grouped as ".".
+func reportDirAt(rel string, depth int) string {
+	parts := strings.Split(filepath.ToSlash(filepath.Dir(rel)), "/")
+	if parts[0] == "." {
+		return "."
+	}
+	return filepath.FromSlash(strings.Join(parts[:min(depth, len(parts))], "/"))
+}
+
+func (r *treeReport) print() {
+	fmt.Printf("%s: %d files, %s\n", r.Root, r.Files, formatSize(r.Bytes))
+	if r.Files == 0 {
+		return
+	}
+
+	fmt.Printf("\nFile sizes:\n")
+	var peak int64
+	for _, b := range r.Histogram {
+		peak = max(peak, b.Files)
+	}
+	for _, b := range r.Histogram {
+		bar := strings.Repeat("█", int(b.Files*30/peak))
+		fmt.Printf("  %-14s %8d files  %10s  %s\n", b.Label, b.Files, formatSize(b.Bytes), bar)
+	}
+
+	if len(r.Dirs) > 0 {
+		fmt.Printf("\nLargest directories:\n")
+		for _, d := range r.Dirs {
+			fmt.Printf("  %10s  %4.1f%%  %8d files  %s\n", formatSize(d.Bytes), pctOf(d.Bytes, r.Bytes), d.Files, d.Path)
+		}
+	}
+	if len(r.Largest) > 0 {
+		fmt.Printf("\nLargest files:\n")
+		for _, f := range r.Largest {
+			fmt.Printf("  %10s  %s\n", formatSize(f.Bytes), f.Path)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+var (
+	// retriesFlag is how many times a failed file is tried again.
+	retriesFlag int
+	// retryWaitFlag is the pause between attempts.
+	retryWaitFlag time.Duration
+)
+
+// withRetries runs transfer, repeating it up to --retries times while it
+// fails. User skips and aborts are never retried, and neither is an
+// attempt that timed out and is still stuck by the time the next one is
+// due: both would write the same part file.
+func withRetries(rel string, transfer func(ctx context.Context) error) error {
+	running, err := watchTransfer(transfer)
+	for attempt := 1; attempt <= retriesFlag && err != nil; attempt++ {
+		if errors.Is(err, errAborted) || errors.Is(err, errSkipped) {
+			break
+		}
+		if running == nil {
+			time.Sleep(retryWaitFlag)
+		} else {
+			select {
+			case <-running:
+			case <-time.After(retryWaitFlag):
+				return fmt.Errorf("%w (not retried: the timed-out attempt is still running)", err)
+			}
+		}
+		logOp(os.Stderr, "[RETRY] %s (attempt %d of %d): %v\n", rel, attempt, retriesFlag, err)
+		if cerr := control.checkpoint(); cerr != nil {
+			return cerr
+		}
+		running, err = watchTransfer(transfer)
+	}
+	return err
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package main
+
+func defaultMaxOpenFiles() int {
+	return 512
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// defaultMaxOpenFiles leaves headroom below the soft RLIMIT_NOFILE for
+// stdio, the dashboard listener and the Go runtime.
+func defaultMaxOpenFiles() int {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil || rl.Cur == 0 {
+		return 256
+	}
+	limit := int(rl.Cur) - 32
+	if rl.Cur > 1<<20 {
+		limit = 1<<20 - 32
+	}
+	if limit < 4 {
+		limit = 4
+	}
+	return limit
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runRobocopy accepts a robocopy-style command line,
+//
+//	lyphotos robocopy <source> <target> [/E] [/MIR] [/PURGE] [/XD dir...] [/XF file...] [/R:n] [/W:n] [/MOV] [/L]
+//
+// translates the switches to native flags and runs the copy, so existing
+// robocopy scripts can be moved over with few changes. Like robocopy it
+// applies the changes unless /L is given.
+func runRobocopy(args []string) {
+	native, err := robocopyArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := flag.CommandLine.Parse(native); err != nil {
+		exit(2)
+	}
+	runCopyMoveOperation()
+}
+
+// robocopyArgs maps robocopy arguments to the equivalent native flags.
+func robocopyArgs(args []string) ([]string, error) {
+	var paths []string
+	native := []string{"--apply"}
+	move := false
+	list := "" // the /XD or /XF list being read
+	for _, arg := range args {
+		upper := strings.ToUpper(arg)
+		if !strings.HasPrefix(arg, "/") || !isRobocopySwitch(upper) {
+			switch {
+			case list == "/XD":
+				native = append(native, "--exclude", arg+"/")
+			case list == "/XF":
+				native = append(native, "--exclude", arg)
+			default:
+				paths = append(paths, arg)
+			}
+			continue
+		}
+		list = ""
+		name, value, _ := strings.Cut(upper, ":")
+		switch name {
+		case "/E", "/S":
+			// Subdirectories are always included
+		case "/XD", "/XF":
+			list = name
+		case "/R", "/W":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid robocopy switch %s", arg)
+			}
+			if name == "/R" {
+				native = append(native, "--retries", value)
+			} else {
+				native = append(native, "--retry-wait", value+"s")
+			}
+		case "/MOV":
+			move = true
+		case "/L":
+			native = native[1:] // list only: drop --apply
+		case "/MIR", "/PURGE":
+			native = append(native, "--delete")
+		}
+	}
+	if len(paths) != 2 {
+		return nil, fmt.Errorf("usage: %s robocopy <source> <target> [switches]", os.Args[0])
+	}
+	if move {
+		native = append(native, "--move")
+	} else {
+		native = append(native, "--copy")
+	}
+	return append(native, "--source", paths[0], "--target", paths[1]), nil
+}
+
+// isRobocopySwitch reports whether arg (upper-cased) is a switch robocopy
+// understands. Anything else starting with a slash is taken as a path.
+func isRobocopySwitch(arg string) bool {
+	name, _, _ := strings.Cut(arg, ":")
+	switch name {
+	case "/E", "/S", "/XD", "/XF", "/R", "/W", "/MOV", "/L", "/MIR", "/PURGE":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// scanInterval throttles the live counter printed while scanning.
+const scanInterval = 200 * time.Millisecond
+
+// scanVisit, when set, is called with every regular file scanSource counts.
+var scanVisit func(rel string, info fs.FileInfo)
+
+// scanSource adds the size of every regular file under root to
+// overallSize, keeping a live counter of files, bytes and the current
+// directory on stderr so large trees don't look frozen. With --scan-cache
+// a recent saved scan is reused, and a fresh one is saved for next time.
+// The cache is left alone when --mounts prunes the tree, so it always
+// holds the whole source.
+func scanSource(root string) {
+	filters.useRoot(root)
+	if excludeDirLargerThan > 0 {
+		dirSizes = map[string]int64{}
+	}
+	useCache := scanCacheTTL > 0 && mountsFlag == mountsInclude
+	if useCache {
+		if c, err := loadScanCache(root, scanCacheTTL); err == nil {
+			sourceCache = c
+			var files int64
+			for _, e := range c.Entries {
+				if e.FMode.IsRegular() && !excludedPath(e.Path, false) {
+					atomic.AddInt64(&overallSize, e.FSize)
+					files++
+					noteScannedFile(e.Path, e.FSize)
+					if scanVisit != nil {
+						scanVisit(e.Path, e)
+					}
+				}
+			}
+			fmt.Fprintf(os.Stderr, "Using cached scan from %s: %d files, %s\n",
+				c.Created.Local().Format(time.DateTime), files, formatSize(atomic.LoadInt64(&overallSize)))
+			return
+		}
+	}
+	var record *scanCache
+	if useCache {
+		record = &scanCache{Root: root, Created: time.Now()}
+	}
+
+	var files int64
+	var lastUpdate time.Time
+	show := func(dir string) {
+		line := fmt.Sprintf("Scanning: %d files, %s  %s", files, formatSize(atomic.LoadInt64(&overallSize)), dir)
+		if plainProgress() {
+			// Only the final count, without redrawing the line
+			if dir == "" {
+				fmt.Fprint(os.Stderr, line)
+			}
+			return
+		}
+		if width, _, err := termSize(int(os.Stderr.Fd())); err == nil && width > 0 {
+			line = truncate(line, width-1)
+		}
+		fmt.Fprintf(os.Stderr, "\r%s\x1b[K", line)
+	}
+
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() && d.Name() == undoDirName {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		// A recorded scan keeps excluded entries so it stays valid when
+		// the patterns change; they are just left out of the totals.
+		skip := excludedPath(rel, d.IsDir())
+		if skip && d.IsDir() && record == nil {
+			return filepath.SkipDir
+		}
+		if d.IsDir() && skipMount(path, rel) {
+			return filepath.SkipDir
+		}
+		if d.IsDir() {
+			if record != nil {
+				record.Entries = append(record.Entries, cachedEntry{Path: rel, FMode: fs.ModeDir | 0o755})
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if d.Type()&os.ModeSymlink == 0 && !skip {
+			atomic.AddInt64(&overallSize, info.Size())
+			files++
+			noteScannedFile(rel, info.Size())
+			if scanVisit != nil {
+				scanVisit(rel, info)
+			}
+		}
+		if record != nil {
+			record.Entries = append(record.Entries, cachedEntry{
+				Path: rel, FMode: info.Mode(), FSize: info.Size(), MTimeNs: info.ModTime().UnixNano(),
+			})
+		}
+		if now := time.Now(); now.Sub(lastUpdate) >= max(scanInterval, progressInterval) {
+			lastUpdate = now
+			rel, _ := filepath.Rel(root, filepath.Dir(path))
+			show(rel)
+		}
+		return nil
+	})
+	show("")
+	fmt.Fprintln(os.Stderr)
+
+	if record != nil {
+		if err := saveScanCache(record); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot save scan cache: %v\n", err)
+		}
+	}
+}
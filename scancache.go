@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// scanCache is a saved source scan. Replaying it lets back-to-back runs
+// skip walking the source tree again until it expires (--scan-cache).
+type scanCache struct {
+	Root    string        `json:"root"`
+	Created time.Time     `json:"created"`
+	Entries []cachedEntry `json:"entries"`
+}
+
+// cachedEntry is one scanned path, relative to the root ("." is the root).
+// It implements fs.DirEntry and fs.FileInfo so walks can replay it.
+type cachedEntry struct {
+	Path    string      `json:"p"`
+	FMode   fs.FileMode `json:"m"`
+	FSize   int64       `json:"s,omitempty"`
+	MTimeNs int64       `json:"t,omitempty"`
+}
+
+func (e cachedEntry) Name() string               { return filepath.Base(e.Path) }
+func (e cachedEntry) IsDir() bool                { return e.FMode.IsDir() }
+func (e cachedEntry) Type() fs.FileMode          { return e.FMode.Type() }
+func (e cachedEntry) Info() (fs.FileInfo, error) { return e, nil }
+func (e cachedEntry) Size() int64                { return e.FSize }
+func (e cachedEntry) Mode() fs.FileMode          { return e.FMode }
+func (e cachedEntry) ModTime() time.Time         { return time.Unix(0, e.MTimeNs) }
+func (e cachedEntry) Sys() any                   { return nil }
+
+var (
+	// scanCacheTTL is how long a saved scan stays valid; 0 disables caching.
+	scanCacheTTL time.Duration
+	// sourceCache is the scan being replayed for the current source, if any.
+	sourceCache *scanCache
+)
+
+// userCacheFile returns the path of a file in the per-user cache
+// directory, creating the directory if needed.
+func userCacheFile(name string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "lyphotos")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+func scanCachePath(root string) (string, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return userCacheFile("scan-" + hex.EncodeToString(sum[:8]) + ".json")
+}
+
+// loadScanCache returns the saved scan of root if it is younger than ttl.
+func loadScanCache(root string, ttl time.Duration) (*scanCache, error) {
+	path, err := scanCachePath(root)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c scanCache
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if c.Root != root || time.Since(c.Created) > ttl {
+		return nil, errors.New("scan cache expired")
+	}
+	return &c, nil
+}
+
+func saveScanCache(c *scanCache) error {
+	path, err := scanCachePath(c.Root)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// walkSource is filepath.WalkDir over a source root, replaying the cached
+// scan instead of touching the disk when one is loaded for root.
+func walkSource(root string, fn fs.WalkDirFunc) error {
+	if sourceCache == nil || sourceCache.Root != root {
+		return filepath.WalkDir(root, fn)
+	}
+	skip := ""
+	for _, e := range sourceCache.Entries {
+		if skip != "" && (e.Path == skip || strings.HasPrefix(e.Path, skip+string(filepath.Separator)) || skip == ".") {
+			continue
+		}
+		skip = ""
+		err := fn(filepath.Join(root, e.Path), e, nil)
+		switch {
+		case err == filepath.SkipAll:
+			return nil
+		case err == filepath.SkipDir && e.IsDir():
+			skip = e.Path
+		case err == filepath.SkipDir:
+			skip = filepath.Dir(e.Path)
+		case err != nil:
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"cmp"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// movedFromDirs are the source directories --move has moved files or
+// symlinks out of. Symlinks are moved by the walk, alongside the
+// transfers, hence the lock.
+var (
+	movedFromMu   sync.Mutex
+	movedFromDirs = map[string]bool{}
+)
+
+// noteMovedOut records that the source entry src was moved away.
+func noteMovedOut(src string) {
+	movedFromMu.Lock()
+	movedFromDirs[filepath.Dir(src)] = true
+	movedFromMu.Unlock()
+}
+
+// removeEmptySourceDirs deletes the source directories a --move left
+// empty, deepest first, so no skeleton of the old tree stays behind. Only
+// directories files were moved out of, and the parents that leaves empty,
+// are considered; ones that were empty already stay, as does the source
+// root itself.
+func removeEmptySourceDirs(srcRoot string) error {
+	candidates := map[string]bool{}
+	for dir := range movedFromDirs {
+		rel, err := filepath.Rel(srcRoot, dir)
+		for ; err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)); rel = filepath.Dir(rel) {
+			candidates[rel] = true
+		}
+	}
+	dirs := slices.Collect(maps.Keys(candidates))
+	slices.SortFunc(dirs, func(a, b string) int {
+		return cmp.Or(cmp.Compare(depth(b), depth(a)), strings.Compare(a, b))
+	})
+
+	for _, rel := range dirs {
+		path := filepath.Join(srcRoot, rel)
+		if entries, err := os.ReadDir(path); err != nil || len(entries) > 0 {
+			continue
+		}
+		fileOps.wait()
+		if err := removeDir(path); err != nil {
+			if err := handleFailure(rel, err); err != nil {
+				return err
+			}
+			continue
+		}
+		logOp(os.Stdout, "[RMDIR] %s%c\n", rel, filepath.Separator)
+	}
+	return nil
+}
+
+// depth is the number of path elements in rel.
+func depth(rel string) int {
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	sparkInterval = 500 * time.Millisecond
+	sparkWidth    = 20
+)
+
+// rateWindow keeps the overall transfer rate of the last few intervals so
+// a sparkline beside the overall progress shows whether the speed is
+// steady, bursty or collapsing.
+type rateWindow struct {
+	mu        sync.Mutex
+	rates     []float64
+	lastBytes int64
+	lastTick  time.Time
+}
+
+var throughput rateWindow
+
+// observe closes the current interval once it is over. It is cheap enough
+// to call on every progress update.
+func (r *rateWindow) observe(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current := atomic.LoadInt64(&overallProgress)
+	if r.lastTick.IsZero() {
+		r.lastTick, r.lastBytes = now, current
+		return
+	}
+	elapsed := now.Sub(r.lastTick)
+	if elapsed < sparkInterval {
+		return
+	}
+	r.rates = append(r.rates, float64(current-r.lastBytes)/elapsed.Seconds())
+	if len(r.rates) > sparkWidth {
+		r.rates = r.rates[len(r.rates)-sparkWidth:]
+	}
+	r.lastTick, r.lastBytes = now, current
+}
+
+func (r *rateWindow) sparkline() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return sparkline(r.rates, sparkWidth)
+}
+
+// sparkline draws the most recent rates as a one-row block graph.
+func sparkline(rates []float64, width int) string {
+	if len(rates) == 0 {
+		return ""
+	}
+	return throughputGraph(rates, width, 1)[0]
+}
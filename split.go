@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// A file larger than --split-size is stored on the target as name.chunk000,
+// name.chunk001, ... plus name.chunks.json describing them, for FAT32 and
+// other targets with a file size limit. The manifest is written last, so
+// a file only counts as stored once it exists. Copying such a tree back
+// without --split-size joins the chunks into the original file.
+const chunkManifestSuffix = ".chunks.json"
+
+// chunkSuffix matches the chunk files of a split file.
+var chunkSuffix = regexp.MustCompile(`\.chunk\d{3,}$`)
+
+// splitSize is the largest file written whole; 0 never splits.
+var splitSize int64
+
+// chunkManifest describes a split file.
+type chunkManifest struct {
+	Size      int64       `json:"size"`
+	ChunkSize int64       `json:"chunk_size"`
+	Chunks    int         `json:"chunks"`
+	MTimeNs   int64       `json:"mtime"`
+	Mode      fs.FileMode `json:"mode"`
+}
+
+// parseSplitSize sets splitSize from --split-size, which only a plain copy
+// can use.
+func parseSplitSize() error {
+	if splitSizeFlag == "" {
+		return nil
+	}
+	size, err := parseSize(splitSizeFlag)
+	if err != nil || size < 1<<20 {
+		return fmt.Errorf("invalid --split-size %q (want at least 1M)", splitSizeFlag)
+	}
+	if moveFlag || appendFlag || inplaceFlag {
+		return fmt.Errorf("--split-size can only be used with a plain copy (not --move, --append or --inplace)")
+	}
+	splitSize = size
+	return nil
+}
+
+func chunkName(path string, i int) string {
+	return fmt.Sprintf("%s.chunk%03d", path, i)
+}
+
+// joining reports whether split files in the source are reassembled.
+// Moves carry them over as they are.
+func joining() bool {
+	return splitSize == 0 && !moveFlag
+}
+
+// splitBase returns the file a chunk or chunk manifest belongs to.
+func splitBase(path string) (string, bool) {
+	if base, ok := strings.CutSuffix(path, chunkManifestSuffix); ok {
+		return base, true
+	}
+	if loc := chunkSuffix.FindStringIndex(path); loc != nil {
+		return path[:loc[0]], true
+	}
+	return "", false
+}
+
+// splitCounterpart reports whether the target entry rel stands for a
+// source file stored the other way round: as chunks of a whole source
+// file, or whole where the source has chunks. --delete keeps those.
+func splitCounterpart(srcRoot, rel string) bool {
+	if splitSize > 0 {
+		base, ok := splitBase(rel)
+		return ok && exists(filepath.Join(srcRoot, base))
+	}
+	return exists(filepath.Join(srcRoot, rel+chunkManifestSuffix))
+}
+
+// chunkedTransfer handles the source file at path if it is split or to
+// be split: a chunk manifest becomes a transfer joining the chunks, the
+// chunks themselves go with it, and with --split-size a large file
+// becomes a transfer writing chunks. One stored already is skipped, or
+// with --existing or --versions redone when the manifest shows the file
+// changed. It reports whether it handled it.
+func chunkedTransfer(path, rel, dstRoot string, d fs.DirEntry, onFile func(op transferOp) error) (bool, error) {
+	if joining() {
+		base, ok := splitBase(rel)
+		if !ok || !exists(filepath.Join(filepath.Dir(path), filepath.Base(base)+chunkManifestSuffix)) {
+			return false, nil
+		}
+		if !strings.HasSuffix(d.Name(), chunkManifestSuffix) {
+			return true, nil
+		}
+		dst := filepath.Join(dstRoot, base)
+		m, err := readChunkManifest(path)
+		if err != nil {
+			return true, handleFailure(rel, err)
+		}
+		replace := false
+		if dstInfo, err := os.Stat(dst); err == nil {
+			if !updatesExisting() || !dstInfo.Mode().IsRegular() || !m.changedFrom(dstInfo) {
+				logOp(os.Stdout, "[SKIP] %s\n", base)
+				atomic.AddInt64(&skipped, 1)
+				return true, nil
+			}
+			replace = true
+		}
+		return true, onFile(transferOp{src: path, dst: dst, rel: base, size: m.Size, join: true, replace: replace})
+	}
+
+	if splitSize == 0 || !d.Type().IsRegular() {
+		return false, nil
+	}
+	info, err := d.Info()
+	if err != nil || info.Size() <= splitSize {
+		return false, nil
+	}
+	dst := filepath.Join(dstRoot, rel)
+	replace := false
+	if exists(dst + chunkManifestSuffix) {
+		m, err := readChunkManifest(dst + chunkManifestSuffix)
+		if !updatesExisting() || (err == nil && !m.changedFrom(info)) {
+			logOp(os.Stdout, "[SKIP] %s\n", rel)
+			atomic.AddInt64(&skipped, 1)
+			return true, nil
+		}
+		replace = true
+	} else if exists(dst) {
+		// Stored whole by an earlier run; leave it to the usual checks
+		return false, nil
+	}
+	return true, onFile(transferOp{src: path, dst: dst, rel: rel, size: info.Size(), split: true, replace: replace})
+}
+
+// updatesExisting reports whether a changed file that is already on the
+// target is transferred again (--existing or --versions).
+func updatesExisting() bool {
+	return (existingFlag || versionsFlag > 0) && !ignoreExistingFlag
+}
+
+// changedFrom reports whether the file info describes differs from the
+// one m was written for, as changedFile compares two files.
+func (m chunkManifest) changedFrom(info fs.FileInfo) bool {
+	return m.Size != info.Size() || !sameModTime(time.Unix(0, m.MTimeNs), info.ModTime())
+}
+
+func readChunkManifest(path string) (chunkManifest, error) {
+	var m chunkManifest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// splitFile copies op.src to op.dst as chunks of --split-size bytes.
+func splitFile(ctx context.Context, op transferOp) error {
+	in, err := openFiles.open(op.src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if err := ensureDir(filepath.Dir(op.dst)); err != nil {
+		return err
+	}
+	if op.replace {
+		if err := clearChunks(op.dst); err != nil {
+			return err
+		}
+	}
+
+	status.setCurrentFile(op.rel)
+	if screen != nil {
+		screen.setFile(op.rel, info.Size())
+	}
+	progress := &progressWriter{fileName: filepath.Base(op.src), total: info.Size(), ctx: ctx}
+	var reader io.Reader = io.TeeReader(in, progress)
+	var hasher hash.Hash
+	if verifyFlag {
+		hasher = newHash()
+		reader = io.TeeReader(reader, hasher)
+	}
+
+	m := chunkManifest{Size: info.Size(), ChunkSize: splitSize, MTimeNs: info.ModTime().UnixNano(), Mode: info.Mode()}
+	err = func() error {
+		for ; int64(m.Chunks)*splitSize < m.Size; m.Chunks++ {
+			if err := writeChunk(chunkName(op.dst, m.Chunks), io.LimitReader(reader, splitSize), info.Mode()); err != nil {
+				return err
+			}
+		}
+		if hasher != nil {
+			if err := verifyJoined(op.dst, m, hex.EncodeToString(hasher.Sum(nil))); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		return writeChunk(op.dst+chunkManifestSuffix, strings.NewReader(string(data)), 0o644)
+	}()
+	if err != nil {
+		atomic.AddInt64(&overallProgress, -atomic.LoadInt64(&progress.current))
+		return err
+	}
+	if screen == nil {
+		endProgressLine()
+		printOverall()
+	}
+	return nil
+}
+
+// clearChunks makes way for a new version of the split file base. The
+// manifest goes first, so the file no longer counts as stored while it is
+// rewritten, and every old chunk goes too, so none outlives a shorter new
+// version. They are kept as --versions or staged for undo like any
+// replaced file, and otherwise removed.
+func clearChunks(base string) error {
+	paths := []string{base + chunkManifestSuffix}
+	for i := 0; exists(chunkName(base, i)); i++ {
+		paths = append(paths, chunkName(base, i))
+	}
+	for _, path := range paths {
+		if err := makeWayFor(path); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeChunk writes r to path by way of a part file and journals it.
+func writeChunk(path string, r io.Reader, mode fs.FileMode) error {
+	part := partPath(path)
+	out, err := openFiles.openFile(part, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, err = copyData(throttle(out), r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		fileOps.wait()
+		err = os.Rename(part, path)
+	}
+	if err != nil {
+		os.Remove(part)
+		return err
+	}
+	return recordCreate(path)
+}
+
+// verifyJoined re-reads the chunks of base and compares their checksum
+// with want (--verify).
+func verifyJoined(base string, m chunkManifest, want string) error {
+	h := newHash()
+	for i := 0; i < m.Chunks; i++ {
+		if err := func() error {
+			f, err := openFiles.open(chunkName(base, i))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = copyData(h, hashedReader{f})
+			return err
+		}(); err != nil {
+			return err
+		}
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("%s: %w (%s %s, expected %s)", base, errVerifyFailed, hashFlag, got, want)
+	}
+	return nil
+}
+
+// joinFile reassembles the split file whose manifest is op.src into
+// op.dst.
+func joinFile(ctx context.Context, op transferOp) error {
+	m, err := readChunkManifest(op.src)
+	if err != nil {
+		return err
+	}
+	if err := ensureDir(filepath.Dir(op.dst)); err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(op.src, chunkManifestSuffix)
+
+	status.setCurrentFile(op.rel)
+	if screen != nil {
+		screen.setFile(op.rel, m.Size)
+	}
+	progress := &progressWriter{fileName: filepath.Base(op.dst), total: m.Size, ctx: ctx}
+	part := partPath(op.dst)
+	err = func() error {
+		out, err := openFiles.openFile(part, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, m.Mode.Perm())
+		if err != nil {
+			return err
+		}
+		var written int64
+		for i := 0; i < m.Chunks && err == nil; i++ {
+			var in *limitedFile
+			if in, err = openFiles.open(chunkName(base, i)); err == nil {
+				var n int64
+				n, err = copyData(throttle(out), io.TeeReader(in, progress))
+				written += n
+				in.Close()
+			}
+		}
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil && written != m.Size {
+			err = fmt.Errorf("%s: chunks hold %d bytes, manifest says %d", op.src, written, m.Size)
+		}
+		if err == nil {
+			err = applyChmod(part, m.Mode)
+		}
+		if err == nil && timesFlag != timesNone {
+			err = os.Chtimes(part, time.Time{}, time.Unix(0, m.MTimeNs))
+		}
+		if err == nil && op.replace {
+			clearReadonly(op.dst)
+			err = makeWayFor(op.dst)
+		} else if err == nil && exists(op.dst) {
+			err = fmt.Errorf("%s: %w", op.dst, fs.ErrExist)
+		}
+		if err == nil {
+			fileOps.wait()
+			err = os.Rename(part, op.dst)
+		}
+		return err
+	}()
+	if err != nil {
+		os.Remove(part)
+		atomic.AddInt64(&overallProgress, -atomic.LoadInt64(&progress.current))
+		return err
+	}
+	if err := recordCreate(op.dst); err != nil {
+		return err
+	}
+	if screen == nil {
+		endProgressLine()
+		printOverall()
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// A transfer that moves less than --min-rate (any data at all by default)
+// for --stall-timeout fails with errStalled, to be retried with --retries
+// or to stop the run with --fail-fast, rather than hang on a dying disk.
+var (
+	stallTimeout time.Duration
+	minRateFlag  string
+	minRate      int64
+)
+
+var errStalled = errors.New("transfer stalled")
+
+func parseStall() error {
+	if minRateFlag == "" {
+		return nil
+	}
+	if stallTimeout <= 0 {
+		return errors.New("--min-rate requires --stall-timeout")
+	}
+	n, err := parseSize(minRateFlag)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("invalid --min-rate %q", minRateFlag)
+	}
+	minRate = n
+	return nil
+}
+
+// watchStall cancels the transfer with errStalled once it has moved less
+// than it should over a whole --stall-timeout, and returns when ctx is
+// done. Time spent paused or held doesn't count, and reading a copy back
+// to verify it counts as moving data.
+func watchStall(ctx context.Context, cancel context.CancelCauseFunc) {
+	moved := func() int64 {
+		return atomic.LoadInt64(&overallProgress) + atomic.LoadInt64(&hashedBytes)
+	}
+	type sample struct {
+		at    time.Time
+		bytes int64
+	}
+	need := max(1, int64(float64(minRate)*stallTimeout.Seconds()))
+	ticker := time.NewTicker(min(time.Second, stallTimeout))
+	defer ticker.Stop()
+	samples := []sample{{time.Now(), moved()}}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			current := moved()
+			if control.isPaused() || len(control.heldBy()) > 0 {
+				samples = []sample{{now, current}}
+				continue
+			}
+			samples = append(samples, sample{now, current})
+			// Drop samples while the next one already covers a full window
+			for len(samples) > 1 && now.Sub(samples[1].at) >= stallTimeout {
+				samples = samples[1:]
+			}
+			if now.Sub(samples[0].at) >= stallTimeout && current-samples[0].bytes < need {
+				cancel(errStalled)
+				return
+			}
+		}
+	}
+}
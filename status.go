@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// failure is a single error encountered during a run.
+type failure struct {
+	Path  string    `json:"path"`
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
+// runStatus is the shared live state of a copy/move that external
+// front-ends (the web dashboard) report on.
+type runStatus struct {
+	mu          sync.Mutex
+	operation   string
+	source      string
+	target      string
+	state       string
+	currentFile string
+	failures    []failure
+}
+
+var status = &runStatus{state: "starting"}
+
+// statusSnapshot is the JSON view of runStatus.
+type statusSnapshot struct {
+	Operation   string    `json:"operation"`
+	Source      string    `json:"source"`
+	Target      string    `json:"target"`
+	State       string    `json:"state"`
+	CurrentFile string    `json:"currentFile"`
+	BytesDone   int64     `json:"bytesDone"`
+	BytesTotal  int64     `json:"bytesTotal"`
+	Copied      int64     `json:"copied"`
+	Skipped     int64     `json:"skipped"`
+	Paused      bool      `json:"paused"`
+	HeldBy      []string  `json:"heldBy,omitempty"`
+	Elapsed     float64   `json:"elapsedSeconds"`
+	Failures    []failure `json:"failures"`
+}
+
+func (s *runStatus) begin(operation, source, target string) {
+	s.mu.Lock()
+	s.operation = operation
+	s.source = source
+	s.target = target
+	s.state = "running"
+	s.mu.Unlock()
+}
+
+func (s *runStatus) setState(state string) {
+	s.mu.Lock()
+	s.state = state
+	s.mu.Unlock()
+}
+
+func (s *runStatus) setCurrentFile(name string) {
+	s.mu.Lock()
+	s.currentFile = name
+	s.mu.Unlock()
+}
+
+// recordError remembers a failed path so it can be listed by front-ends.
+func (s *runStatus) recordError(path string, err error) {
+	f := failure{Path: path, Error: err.Error(), Time: time.Now()}
+	s.mu.Lock()
+	s.failures = append(s.failures, f)
+	s.mu.Unlock()
+
+	if webhookFlag != "" && webhookErrors {
+		queueWebhookError(f)
+	}
+}
+
+func (s *runStatus) snapshot() statusSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return statusSnapshot{
+		Operation:   s.operation,
+		Source:      s.source,
+		Target:      s.target,
+		State:       s.state,
+		CurrentFile: s.currentFile,
+		BytesDone:   atomic.LoadInt64(&overallProgress),
+		BytesTotal:  atomic.LoadInt64(&overallSize),
+		Copied:      atomic.LoadInt64(&copied),
+		Skipped:     atomic.LoadInt64(&skipped),
+		Paused:      control.isPaused(),
+		HeldBy:      control.heldBy(),
+		Elapsed:     time.Since(startTime).Seconds(),
+		Failures:    append([]failure(nil), s.failures...),
+	}
+}
+
+// finishRun records the final state of a copy/move and fires the
+// completion notifications that were requested on the command line.
+func finishRun(state string) {
+	status.setState(state)
+	snap := status.snapshot()
+	writeRunSummary(snap)
+	if webhookFlag != "" && webhookErrors {
+		flushWebhookErrors()
+	}
+	if !applyFlag {
+		return
+	}
+	if notifyFlag {
+		if err := desktopNotify("lyphotos", summaryLine(snap)); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: desktop notification failed: %v\n", err)
+		}
+	}
+	if webhookFlag != "" {
+		if err := postWebhook(webhookFlag, webhookEvent{Event: "summary", Summary: &snap}); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: webhook failed: %v\n", err)
+		}
+	}
+	if mailConfig.enabled() {
+		if err := sendReport(mailConfig, snap); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: email report failed: %v\n", err)
+		}
+	}
+}
+
+// summaryLine describes a finished run in one sentence.
+func summaryLine(s statusSnapshot) string {
+	switch s.State {
+	case "failed":
+		msg := fmt.Sprintf("%s failed after %d files", s.Operation, s.Copied)
+		if len(s.Failures) > 0 {
+			msg += ": " + s.Failures[len(s.Failures)-1].Error
+		}
+		return msg
+	case "aborted":
+		return fmt.Sprintf("%s aborted after %d files", s.Operation, s.Copied)
+	case "stopped":
+		return fmt.Sprintf("%s stopped at the transfer limit after %d files, %s", s.Operation, s.Copied, formatSize(s.BytesDone))
+	default:
+		return fmt.Sprintf("%s complete: %d files, %d skipped, %s", s.Operation, s.Copied, s.Skipped, formatSize(s.BytesDone))
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// writeChecksumsFlag names a checksum file to write into the target after
+// a run (--write-checksums), in the format of sha256sum, md5sum and b3sum,
+// so coreutils can check the copy later.
+var writeChecksumsFlag string
+
+// copiedSums holds the checksums of files copied this run, so the
+// checksum file doesn't have to read them back.
+var copiedSums = struct {
+	sync.Mutex
+	m map[string]string
+}{m: map[string]string{}}
+
+func recordSum(rel, sum string) {
+	copiedSums.Lock()
+	copiedSums.m[rel] = sum
+	copiedSums.Unlock()
+}
+
+// sumFileHash returns the algorithm a checksum file's name implies
+// (SHA256SUMS, md5sum.txt, b3sums), or "" if it doesn't.
+func sumFileHash(name string) string {
+	name = strings.ToLower(filepath.Base(name))
+	switch {
+	case strings.Contains(name, "sha256"):
+		return "sha256"
+	case strings.Contains(name, "md5"):
+		return "md5"
+	case strings.Contains(name, "b3") || strings.Contains(name, "blake3"):
+		return "blake3"
+	case strings.Contains(name, "xxh"):
+		return "xxh3"
+	}
+	return ""
+}
+
+// useSumFileHash makes --hash match the --write-checksums file name, so
+// an md5sum.txt really holds MD5 sums.
+func useSumFileHash() error {
+	algo := sumFileHash(writeChecksumsFlag)
+	if writeChecksumsFlag == "" || algo == "" || algo == hashFlag {
+		return nil
+	}
+	given := false
+	flag.Visit(func(f *flag.Flag) { given = given || f.Name == "hash" })
+	if given {
+		return fmt.Errorf("--write-checksums %s implies --hash %s, not %s", writeChecksumsFlag, algo, hashFlag)
+	}
+	hashFlag = algo
+	return nil
+}
+
+// writeSumFile lists every file in dstRoot with its checksum in the
+// --write-checksums file, which a relative name puts in dstRoot.
+func writeSumFile(dstRoot string) error {
+	path := writeChecksumsFlag
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dstRoot, path)
+	}
+	var b strings.Builder
+	err := filepath.WalkDir(dstRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == undoDirName {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(d.Name(), partSuffix) || p == path {
+			return nil
+		}
+		rel, _ := filepath.Rel(dstRoot, p)
+		copiedSums.Lock()
+		sum, ok := copiedSums.m[rel]
+		copiedSums.Unlock()
+		if !ok {
+			if sum, err = hashFile(p); err != nil {
+				return err
+			}
+		}
+		b.WriteString(formatSumLine(sum, filepath.ToSlash(rel)))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	part := partPath(path)
+	if err := os.WriteFile(part, []byte(b.String()), 0o644); err != nil {
+		return err
+	}
+	fileOps.wait()
+	if err := os.Rename(part, path); err != nil {
+		os.Remove(part)
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s checksums to %s\n", hashFlag, path)
+	return nil
+}
+
+// formatSumLine writes one line the way sha256sum does, escaping names
+// with a backslash or newline and marking such lines with a leading
+// backslash.
+func formatSumLine(sum, name string) string {
+	if !strings.ContainsAny(name, "\\\n") {
+		return sum + "  " + name + "\n"
+	}
+	name = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(name)
+	return `\` + sum + "  " + name + "\n"
+}
+
+// parseSumLine reads a line written by sha256sum and friends, in text
+// ("  ") or binary (" *") mode.
+func parseSumLine(line string) (sum, name string, ok bool) {
+	escaped := strings.HasPrefix(line, `\`)
+	if escaped {
+		line = line[1:]
+	}
+	sum, name, ok = strings.Cut(line, " ")
+	if !ok || len(name) < 2 || name[0] != ' ' && name[0] != '*' {
+		return "", "", false
+	}
+	name = name[1:]
+	if escaped {
+		name = strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(name)
+	}
+	return strings.ToLower(sum), name, true
+}
+
+// checkSumFile verifies the files listed in the checksum file sumPath,
+// relative to root, reporting each mismatch or missing file. With detect
+// set the algorithm comes from the file name or, failing that, the
+// length of the sums; otherwise --hash is used.
+func checkSumFile(sumPath, root string, detect bool) (problems, checked int, err error) {
+	f, err := os.Open(sumPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	guess := false
+	if detect {
+		if algo := sumFileHash(sumPath); algo != "" {
+			hashFlag = algo
+		} else {
+			guess = true
+		}
+	}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		want, name, ok := parseSumLine(line)
+		if !ok {
+			return problems, checked, fmt.Errorf("%s:%d: not a checksum line", sumPath, n)
+		}
+		if guess {
+			switch len(want) {
+			case 16:
+				hashFlag = "xxh3"
+			case 32:
+				hashFlag = "md5"
+			case 64:
+				hashFlag = "sha256"
+			}
+			guess = false
+		}
+
+		checked++
+		rel := filepath.FromSlash(name)
+		got, err := hashFile(filepath.Join(root, rel))
+		switch {
+		case os.IsNotExist(err):
+			problems++
+			logOp(os.Stdout, "[MISSING] %s\n", rel)
+		case err != nil:
+			return problems, checked, err
+		case got != want:
+			problems++
+			logOp(os.Stdout, "[CHECKSUM] %s (%s %s, expected %s)\n", rel, hashFlag, got, want)
+		}
+	}
+	return problems, checked, sc.Err()
+}
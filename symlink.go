@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// Values accepted by --dangling-links.
+const (
+	danglingKeep  = "keep"
+	danglingSkip  = "skip"
+	danglingError = "error"
+)
+
+// Values accepted by --rewrite-links.
+const (
+	rewriteKeep     = "keep"
+	rewriteTarget   = "target"
+	rewriteRelative = "relative"
+)
+
+var (
+	// linksFlag recreates source symlinks on the target instead of
+	// leaving them out.
+	linksFlag bool
+	// rewriteLinksFlag says what becomes of absolute link targets inside
+	// the source: kept as they are, pointed at the same path under the
+	// target, or made relative to the link.
+	rewriteLinksFlag = rewriteKeep
+	// danglingLinksFlag says what --links does with a symlink to nothing:
+	// copy it anyway, leave it out, or report it as a failure.
+	danglingLinksFlag = danglingKeep
+	// danglingLinks counts the symlinks to nothing that were seen.
+	danglingLinks int64
+)
+
+func validateDanglingLinks(mode string) error {
+	switch mode {
+	case danglingKeep, danglingSkip, danglingError:
+		return nil
+	}
+	return fmt.Errorf("invalid --dangling-links %q (want keep, skip or error)", mode)
+}
+
+// checkDangling applies --dangling-links to the symlink at path and
+// reports whether it should still be copied.
+func checkDangling(path, rel string) (bool, error) {
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return true, nil
+	}
+	atomic.AddInt64(&danglingLinks, 1)
+	switch danglingLinksFlag {
+	case danglingSkip:
+		logOp(os.Stdout, "[SKIP] %s (dangling symlink)\n", rel)
+		atomic.AddInt64(&skipped, 1)
+		return false, nil
+	case danglingError:
+		target, _ := os.Readlink(path)
+		return false, fmt.Errorf("dangling symlink to %s", target)
+	}
+	return true, nil
+}
+
+// logDanglingLinks adds the count of dangling symlinks to the summary.
+func logDanglingLinks() {
+	n := atomic.LoadInt64(&danglingLinks)
+	if n == 0 {
+		return
+	}
+	what := map[string]string{danglingKeep: "copied", danglingSkip: "skipped", danglingError: "failed"}[danglingLinksFlag]
+	logSummary("  %d dangling symlinks %s\n", n, what)
+}
+
+func validateRewriteLinks(mode string) error {
+	switch mode {
+	case rewriteKeep, rewriteTarget, rewriteRelative:
+		return nil
+	}
+	return fmt.Errorf("invalid --rewrite-links %q (want keep, target or relative)", mode)
+}
+
+// linkTarget returns what the copy of the symlink at path, rel in srcRoot,
+// should point to under --rewrite-links. Relative targets and absolute
+// ones outside the source are kept as they are.
+func linkTarget(path, rel, srcRoot, dstRoot string) (string, error) {
+	target, err := os.Readlink(path)
+	if err != nil || rewriteLinksFlag == rewriteKeep || !filepath.IsAbs(target) {
+		return target, err
+	}
+	absSrc, err := filepath.Abs(srcRoot)
+	if err != nil {
+		return "", err
+	}
+	inner, err := filepath.Rel(absSrc, filepath.Clean(target))
+	if err != nil || inner == ".." || strings.HasPrefix(inner, ".."+string(filepath.Separator)) {
+		return target, nil
+	}
+	if rewriteLinksFlag == rewriteRelative {
+		return filepath.Rel(filepath.Dir(rel), inner)
+	}
+	absDst, err := filepath.Abs(dstRoot)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(absDst, inner), nil
+}
+
+// copySymlink recreates the symlink at path as dst, or only lists it in
+// preview mode. A move removes the source link afterwards.
+func copySymlink(path, dst, rel, srcRoot, dstRoot string) error {
+	if ok, err := checkDangling(path, rel); !ok {
+		return err
+	}
+	target, err := linkTarget(path, rel, srcRoot, dstRoot)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		// A link to nothing isn't seen by the Stat of the walk
+		logOp(os.Stdout, "[SKIP] %s\n", rel)
+		atomic.AddInt64(&skipped, 1)
+		return nil
+	}
+	if !applyFlag {
+		atomic.AddInt64(&copied, 1)
+		logOp(os.Stdout, "[LINK] %s -> %s\n", rel, target)
+		return nil
+	}
+	logOp(os.Stderr, "[LINK] %s -> %s\n", rel, target)
+	if err := ensureDir(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	fileOps.wait()
+	if err := os.Symlink(target, dst); err != nil {
+		return err
+	}
+	if err := recordCreate(dst); err != nil {
+		return err
+	}
+	atomic.AddInt64(&copied, 1)
+	if moveFlag {
+		if err := removeFile(path); err != nil {
+			return err
+		}
+		noteMovedOut(path)
+	}
+	return nil
+}
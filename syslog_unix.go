@@ -0,0 +1,33 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+)
+
+// syslogSink sends the run log to the local syslog daemon.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func openSyslog() (logSink, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, "lyphotos")
+	if err != nil {
+		return nil, err
+	}
+	return syslogSink{w}, nil
+}
+
+func (s syslogSink) printf(format string, args ...any) {
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	if isErrorLine(msg) {
+		s.w.Err(msg)
+	} else {
+		s.w.Info(msg)
+	}
+}
+
+func (s syslogSink) close() { s.w.Close() }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdNotify sends a state such as "READY=1" to the service manager when
+// running under systemd with Type=notify; otherwise it does nothing.
+func sdNotify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	if path[0] == '@' {
+		path = "\x00" + path[1:] // abstract socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// startWatchdog pings the systemd watchdog at half of WatchdogSec= for as
+// long as the process runs.
+func startWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	go func() {
+		for range time.Tick(time.Duration(usec) * time.Microsecond / 2) {
+			sdNotify("WATCHDOG=1")
+		}
+	}()
+}
+
+// activatedListener returns the socket systemd passed in with
+// FileDescriptorName=name (socket activation), or nil if there is none.
+func activatedListener(name string) net.Listener {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < count && i < len(names); i++ {
+		if names[i] != name {
+			continue
+		}
+		const firstFD = 3
+		f := os.NewFile(uintptr(firstFD+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil
+		}
+		return ln
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+func sdNotify(state string) error { return nil }
+
+func startWatchdog() {}
+
+func activatedListener(name string) net.Listener { return nil }
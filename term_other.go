@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package main
+
+import "errors"
+
+var errNoTerminal = errors.New("terminal control not supported on this platform")
+
+func termSize(fd int) (width, height int, err error) {
+	return 0, 0, errNoTerminal
+}
+
+// makeCbreak is unsupported here; keys are then read line by line.
+func makeCbreak(fd int) (func(), error) {
+	return nil, errNoTerminal
+}
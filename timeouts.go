@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+var (
+	// fileTimeout bounds each attempt at transferring one file
+	// (--file-timeout); runTimeout bounds the whole run (--run-timeout).
+	fileTimeout time.Duration
+	runTimeout  time.Duration
+
+	errFileTimeout = errors.New("file timeout reached")
+	// errRunTimeout is an abort, so it stops the run like one.
+	errRunTimeout = fmt.Errorf("%w: run timeout reached", errAborted)
+)
+
+// timeoutGrace is how long a transfer that has been cancelled gets to
+// notice at its next read or write and clean up. One stuck in a system
+// call, as on a hung NFS mount, is abandoned after that.
+const timeoutGrace = 5 * time.Second
+
+var (
+	// runCtx ends when --run-timeout is reached, or stopRunTimeout is
+	// called once the transfers are over.
+	runCtx         = context.Background()
+	stopRunTimeout = func() {}
+)
+
+// startRunTimeout aborts the run once --run-timeout has passed, and exits
+// if the transfer in progress doesn't respond to that.
+func startRunTimeout() {
+	if runTimeout <= 0 {
+		return
+	}
+	runCtx, stopRunTimeout = context.WithTimeoutCause(context.Background(), runTimeout, errRunTimeout)
+	context.AfterFunc(runCtx, func() {
+		if !errors.Is(context.Cause(runCtx), errRunTimeout) {
+			return
+		}
+		control.abortWith(errRunTimeout)
+		time.AfterFunc(timeoutGrace, func() {
+			fmt.Fprintf(os.Stderr, "Error: run timeout reached and the current transfer is not responding\n")
+			exit(1)
+		})
+	})
+}
+
+// watchTransfer runs one transfer attempt under --file-timeout and
+// --stall-timeout, passing it the context its reads and writes stop at.
+// A transfer that overruns or stalls fails with errFileTimeout or
+// errStalled, so the run carries on with the next file even when this one
+// is stuck for good. An attempt abandoned that way may still be running;
+// running then delivers its result once it ends.
+func watchTransfer(transfer func(ctx context.Context) error) (running <-chan error, err error) {
+	if fileTimeout <= 0 && stallTimeout <= 0 {
+		return nil, transfer(runCtx)
+	}
+	ctx, cancel := context.WithCancelCause(runCtx)
+	defer cancel(nil)
+	if fileTimeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeoutCause(ctx, fileTimeout, errFileTimeout)
+		defer stop()
+	}
+	if stallTimeout > 0 {
+		go watchStall(ctx, cancel)
+	}
+	done := make(chan error, 1)
+	go func() { done <- transfer(ctx) }()
+	select {
+	case err := <-done:
+		return nil, err
+	case <-ctx.Done():
+	}
+	select {
+	case err := <-done:
+		return nil, err
+	case <-time.After(timeoutGrace):
+		return done, context.Cause(ctx)
+	}
+}
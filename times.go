@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Values accepted by --times.
+const (
+	timesNone  = "none"
+	timesMtime = "mtime"
+	timesAll   = "all"
+)
+
+// Values accepted by --time-precision.
+const (
+	precisionNanosecond = "nanosecond"
+	precisionSecond     = "second"
+)
+
+// timesFlag selects which source timestamps copies keep: none, the
+// modification time, or all of access, modification and (where the
+// platform can set it) creation time.
+var timesFlag = timesMtime
+
+// timePrecisionFlag is the precision timestamps are compared and set at.
+// Whole seconds suit filesystems, often network ones, that drop the
+// fraction, so every file would otherwise look changed on the next run.
+var timePrecisionFlag = precisionNanosecond
+
+func validateTimes(times string) error {
+	switch times {
+	case timesNone, timesMtime, timesAll:
+		return nil
+	}
+	return fmt.Errorf("invalid --times %q (want none, mtime or all)", times)
+}
+
+func validateTimePrecision(precision string) error {
+	switch precision {
+	case precisionNanosecond, precisionSecond:
+		return nil
+	}
+	return fmt.Errorf("invalid --time-precision %q (want nanosecond or second)", precision)
+}
+
+// atPrecision cuts t to --time-precision.
+func atPrecision(t time.Time) time.Time {
+	if timePrecisionFlag == precisionSecond {
+		return t.Truncate(time.Second)
+	}
+	return t
+}
+
+// preserveTimes gives path the timestamps of src that --times asks for.
+// src should be taken before the source was read, which moves its atime.
+func preserveTimes(path string, src fs.FileInfo) error {
+	var atime time.Time
+	switch timesFlag {
+	case timesNone:
+		return nil
+	case timesAll:
+		var btime time.Time
+		atime, btime = fileTimes(src)
+		atime = atPrecision(atime)
+		if !btime.IsZero() {
+			if err := setBirthTime(path, atPrecision(btime)); err != nil {
+				return err
+			}
+		}
+	}
+	// A zero atime is left unchanged
+	return os.Chtimes(path, atime, atPrecision(src.ModTime()))
+}
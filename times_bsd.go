@@ -0,0 +1,19 @@
+//go:build darwin || freebsd || netbsd
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// fileTimes returns the access time of info and, where the platform
+// records one, its creation time.
+func fileTimes(info fs.FileInfo) (atime, btime time.Time) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		atime = time.Unix(st.Atimespec.Unix())
+		btime = time.Unix(st.Birthtimespec.Unix())
+	}
+	return atime, btime
+}
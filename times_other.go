@@ -0,0 +1,14 @@
+//go:build !linux && !openbsd && !darwin && !freebsd && !netbsd && !windows
+
+package main
+
+import (
+	"io/fs"
+	"time"
+)
+
+// fileTimes can't read extra timestamps here, so only the modification
+// time is kept.
+func fileTimes(info fs.FileInfo) (atime, btime time.Time) {
+	return time.Time{}, time.Time{}
+}
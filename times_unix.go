@@ -0,0 +1,18 @@
+//go:build linux || openbsd
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// fileTimes returns the access time of info and, where the platform
+// records one, its creation time.
+func fileTimes(info fs.FileInfo) (atime, btime time.Time) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		atime = time.Unix(st.Atim.Unix())
+	}
+	return atime, btime
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// fileTimes returns the access and creation times of info.
+func fileTimes(info fs.FileInfo) (atime, btime time.Time) {
+	if d, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		atime = time.Unix(0, d.LastAccessTime.Nanoseconds())
+		btime = time.Unix(0, d.CreationTime.Nanoseconds())
+	}
+	return atime, btime
+}
+
+// setBirthTime sets the creation time of path with SetFileTime.
+func setBirthTime(path string, btime time.Time) error {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	// Backup semantics are needed to open directories
+	h, err := syscall.CreateFile(name, syscall.FILE_WRITE_ATTRIBUTES, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return &fs.PathError{Op: "setfiletime", Path: path, Err: err}
+	}
+	defer syscall.CloseHandle(h)
+	ctime := syscall.NsecToFiletime(btime.UnixNano())
+	if err := syscall.SetFileTime(h, &ctime, nil, nil); err != nil {
+		return &fs.PathError{Op: "setfiletime", Path: path, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// TLS settings for the webhook, SMTP and https:// proxy connections, for
+// servers with a private CA or a self-signed certificate.
+var (
+	caCertFlag         string
+	clientCertFlag     string
+	clientKeyFlag      string
+	insecureSkipVerify bool
+)
+
+// tlsBase holds the certificates loaded by useTLSFlags; connections clone
+// it and fill in their own server name.
+var tlsBase = &tls.Config{}
+
+// useTLSFlags loads --ca-cert and --client-cert/--client-key and applies
+// them to the webhook client. The CA bundle is trusted in addition to the
+// system roots.
+func useTLSFlags() error {
+	cfg := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caCertFlag != "" {
+		pem, err := os.ReadFile(caCertFlag)
+		if err != nil {
+			return fmt.Errorf("--ca-cert: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("--ca-cert: no PEM certificates in %s", caCertFlag)
+		}
+		cfg.RootCAs = pool
+	}
+	if clientKeyFlag != "" && clientCertFlag == "" {
+		return fmt.Errorf("--client-key requires --client-cert")
+	}
+	if clientCertFlag != "" {
+		// A single PEM file may hold both the certificate and the key
+		key := clientKeyFlag
+		if key == "" {
+			key = clientCertFlag
+		}
+		cert, err := tls.LoadX509KeyPair(clientCertFlag, key)
+		if err != nil {
+			return fmt.Errorf("--client-cert: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	tlsBase = cfg
+	webhookClient.Transport.(*http.Transport).TLSClientConfig = cfg
+	return nil
+}
+
+// tlsConfigFor returns the TLS configuration for a connection to host.
+func tlsConfigFor(host string) *tls.Config {
+	cfg := tlsBase.Clone()
+	cfg.ServerName = host
+	return cfg
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tuiScreen renders a full-screen view of a copy/move run with plain ANSI
+// escape sequences: overall and per-file bars, a throughput graph and a
+// scrolling log of file operations. Keys p, s and q pause, skip and abort.
+type tuiScreen struct {
+	mu        sync.Mutex
+	title     string
+	log       []string
+	file      string
+	fileDone  int64
+	fileSize  int64
+	rates     []float64
+	lastBytes int64
+	lastTick  time.Time
+	restore   func()
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// screen is non-nil while the TUI owns the terminal.
+var screen *tuiScreen
+
+const tuiLogLimit = 1000
+
+func startTUI(title string) *tuiScreen {
+	t := &tuiScreen{
+		title:    title,
+		lastTick: time.Now(),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if restore, err := makeCbreak(int(os.Stdin.Fd())); err == nil {
+		t.restore = restore
+	}
+
+	// Enter the alternate screen and hide the cursor
+	fmt.Fprint(os.Stderr, "\x1b[?1049h\x1b[?25l")
+
+	go t.readKeys()
+	go t.renderLoop()
+
+	// Ctrl-C must go through abort so the terminal is restored
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		select {
+		case <-sigs:
+			control.abort()
+		case <-t.done:
+		}
+		signal.Stop(sigs)
+	}()
+	return t
+}
+
+// stop tears the screen down and gives the terminal back to the shell.
+func (t *tuiScreen) stop() {
+	close(t.done)
+	<-t.stopped
+	fmt.Fprint(os.Stderr, "\x1b[?25h\x1b[?1049l")
+	if t.restore != nil {
+		t.restore()
+	}
+}
+
+func (t *tuiScreen) logf(format string, args ...any) {
+	line := fmt.Sprintf(format, args...)
+	t.mu.Lock()
+	t.log = append(t.log, strings.TrimRight(line, "\n"))
+	if len(t.log) > tuiLogLimit {
+		t.log = t.log[len(t.log)-tuiLogLimit:]
+	}
+	t.mu.Unlock()
+}
+
+func (t *tuiScreen) setFile(name string, size int64) {
+	t.mu.Lock()
+	t.file = name
+	t.fileSize = size
+	t.fileDone = 0
+	t.mu.Unlock()
+}
+
+func (t *tuiScreen) fileProgress(done int64) {
+	t.mu.Lock()
+	t.fileDone = done
+	t.mu.Unlock()
+}
+
+func (t *tuiScreen) readKeys() {
+	in := bufio.NewReader(os.Stdin)
+	for {
+		b, err := in.ReadByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case 'p', 'P', ' ':
+			control.togglePause()
+		case 's', 'S':
+			control.skipCurrent()
+		case 'q', 'Q', 3:
+			control.abort()
+		}
+	}
+}
+
+func (t *tuiScreen) renderLoop() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.done:
+			return
+		case now := <-ticker.C:
+			t.sample(now)
+			t.render()
+		}
+	}
+}
+
+// sample records the aggregate throughput since the previous tick.
+func (t *tuiScreen) sample(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	current := atomic.LoadInt64(&overallProgress)
+	elapsed := now.Sub(t.lastTick).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	t.rates = append(t.rates, float64(current-t.lastBytes)/elapsed)
+	if len(t.rates) > 512 {
+		t.rates = t.rates[len(t.rates)-512:]
+	}
+	t.lastBytes = current
+	t.lastTick = now
+}
+
+func (t *tuiScreen) render() {
+	width, height, err := termSize(int(os.Stderr.Fd()))
+	if err != nil || width < 20 || height < 10 {
+		width, height = 80, 24
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var lines []string
+	lines = append(lines, truncate(t.title, width))
+
+	done := atomic.LoadInt64(&overallProgress)
+	total := atomic.LoadInt64(&overallSize)
+	barWidth := width - 40 - sparkWidth
+	if barWidth < 10 {
+		barWidth = 10
+	}
+	spark := sparkline(t.rates, sparkWidth)
+	if total > 0 {
+		lines = append(lines, truncate(fmt.Sprintf("Overall   %s %3d%%  %s / %s  %s",
+			tuiBar(done, total, barWidth), percent(done, total), formatSize(done), formatSize(total), spark), width))
+	} else {
+		lines = append(lines, truncate(fmt.Sprintf("Overall   %d files, %s so far  %s", atomic.LoadInt64(&copied), formatSize(done), spark), width))
+	}
+	if t.file != "" {
+		lines = append(lines, truncate(fmt.Sprintf("Worker 1  %s %3d%%  %s",
+			tuiBar(t.fileDone, t.fileSize, barWidth), percent(t.fileDone, t.fileSize), t.file), width))
+	} else {
+		lines = append(lines, "Worker 1  idle")
+	}
+
+	var rate float64
+	if len(t.rates) > 0 {
+		rate = t.rates[len(t.rates)-1]
+	}
+	lines = append(lines, fmt.Sprintf("Throughput %s/s", formatSize(int64(rate))))
+	lines = append(lines, throughputGraph(t.rates, width, 3)...)
+	lines = append(lines, strings.Repeat("─", width))
+
+	footer := "[p] pause  [s] skip file  [q] abort"
+	if control.isPaused() {
+		footer += "   ** PAUSED **"
+	} else if held := control.heldBy(); len(held) > 0 {
+		footer += "   ** WAITING: " + strings.Join(held, ", ") + " **"
+	}
+
+	logRows := height - len(lines) - 1
+	start := len(t.log) - logRows
+	if start < 0 {
+		start = 0
+	}
+	for _, l := range t.log[start:] {
+		lines = append(lines, truncate(l, width))
+	}
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, truncate(footer, width))
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, l := range lines {
+		b.WriteString(l)
+		b.WriteString("\x1b[K")
+		if i < len(lines)-1 {
+			b.WriteString("\n")
+		}
+	}
+	fmt.Fprint(os.Stderr, b.String())
+}
+
+func tuiBar(done, total int64, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = int(done * int64(width) / total)
+	}
+	if filled > width {
+		filled = width
+	}
+	return "[" + strings.Repeat("█", filled) + strings.Repeat(" ", width-filled) + "]"
+}
+
+// throughputGraph draws the most recent rates as a block graph rows high,
+// scaled to the largest visible sample.
+func throughputGraph(rates []float64, width, rows int) []string {
+	if len(rates) > width {
+		rates = rates[len(rates)-width:]
+	}
+	var peak float64
+	for _, r := range rates {
+		if r > peak {
+			peak = r
+		}
+	}
+	blocks := []rune(" ▁▂▃▄▅▆▇█")
+	graph := make([]string, rows)
+	for row := 0; row < rows; row++ {
+		var b strings.Builder
+		floor := float64(rows-row-1) * 8
+		for _, r := range rates {
+			level := 0.0
+			if peak > 0 {
+				level = r / peak * float64(rows*8)
+			}
+			cell := int(level - floor)
+			if cell < 0 {
+				cell = 0
+			}
+			if cell > 8 {
+				cell = 8
+			}
+			b.WriteRune(blocks[cell])
+		}
+		graph[row] = b.String()
+	}
+	return graph
+}
+
+func percent(done, total int64) int64 {
+	if total <= 0 {
+		return 0
+	}
+	pct := done * 100 / total
+	if pct > 100 {
+		pct = 100
+	}
+	return pct
+}
+
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if len(r) <= width {
+		return s
+	}
+	if width <= 1 {
+		return string(r[:width])
+	}
+	return string(r[:width-1]) + "…"
+}
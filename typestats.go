@@ -0,0 +1,103 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// fileCategories groups extensions for the end-of-run breakdown; anything
+// not listed counts as "other".
+var fileCategories = map[string][]string{
+	"images":    {".jpg", ".jpeg", ".png", ".gif", ".heic", ".heif", ".webp", ".avif", ".tif", ".tiff", ".bmp", ".svg", ".dng", ".cr2", ".cr3", ".nef", ".arw", ".orf", ".rw2", ".raf", ".raw"},
+	"video":     {".mp4", ".m4v", ".mov", ".avi", ".mkv", ".mts", ".m2ts", ".3gp", ".wmv", ".webm", ".mpg", ".mpeg"},
+	"documents": {".pdf", ".txt", ".md", ".rtf", ".csv", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx", ".odt", ".ods", ".odp", ".pages", ".numbers", ".key", ".epub"},
+}
+
+// categoryOrder is the order the breakdown is printed in.
+var categoryOrder = []string{"images", "video", "documents", "other"}
+
+// typeCount is what was transferred of one category or extension.
+type typeCount struct {
+	files int64
+	bytes int64
+}
+
+// typeStats tallies finished transfers by extension.
+var typeStats struct {
+	sync.Mutex
+	byExt map[string]*typeCount
+}
+
+// fileCategory returns the category of an extension such as ".jpg".
+func fileCategory(ext string) string {
+	for name, exts := range fileCategories {
+		if slices.Contains(exts, ext) {
+			return name
+		}
+	}
+	return "other"
+}
+
+// recordType adds a finished transfer to the per-extension tally.
+func recordType(op transferOp) {
+	ext := strings.ToLower(filepath.Ext(op.rel))
+	typeStats.Lock()
+	defer typeStats.Unlock()
+	if typeStats.byExt == nil {
+		typeStats.byExt = map[string]*typeCount{}
+	}
+	c := typeStats.byExt[ext]
+	if c == nil {
+		c = &typeCount{}
+		typeStats.byExt[ext] = c
+	}
+	c.files++
+	c.bytes += op.size
+}
+
+// logTypeStats prints what was transferred per category, with its most
+// common extensions, after the closing summary line.
+func logTypeStats() {
+	typeStats.Lock()
+	defer typeStats.Unlock()
+	if len(typeStats.byExt) == 0 {
+		return
+	}
+	totals := map[string]*typeCount{}
+	exts := map[string][]string{}
+	for ext, c := range typeStats.byExt {
+		name := fileCategory(ext)
+		if totals[name] == nil {
+			totals[name] = &typeCount{}
+		}
+		totals[name].files += c.files
+		totals[name].bytes += c.bytes
+		exts[name] = append(exts[name], ext)
+	}
+	for _, name := range categoryOrder {
+		total := totals[name]
+		if total == nil {
+			continue
+		}
+		list := exts[name]
+		slices.SortFunc(list, func(a, b string) int {
+			return cmp.Or(cmp.Compare(typeStats.byExt[b].files, typeStats.byExt[a].files), strings.Compare(a, b))
+		})
+		var parts []string
+		for _, ext := range list[:min(len(list), 5)] {
+			label := ext
+			if label == "" {
+				label = "no extension"
+			}
+			parts = append(parts, fmt.Sprintf("%s %d", label, typeStats.byExt[ext].files))
+		}
+		if len(list) > 5 {
+			parts = append(parts, "...")
+		}
+		logSummary("  %-10s %6d files  %10s  (%s)\n", name, total.files, formatSize(total.bytes), strings.Join(parts, ", "))
+	}
+}
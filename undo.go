@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// undoDirName holds undo logs, and the run lock, inside the tree they
+// protect. Walks skip it.
+const undoDirName = ".lyphotos-undo"
+
+// undoEntry is one journaled change. Paths are absolute.
+type undoEntry struct {
+	Op     string `json:"op"` // create, mkdir, rename, delete, append, rmdir
+	Path   string `json:"path"`
+	From   string `json:"from,omitempty"`
+	Staged string `json:"staged,omitempty"`
+	Size   int64  `json:"size,omitempty"` // length before an append
+}
+
+// undoJournal records every change a run makes to a tree. Deleted files
+// are moved into a staging directory next to the journal rather than
+// removed, so "undo" can put everything back.
+type undoJournal struct {
+	mu     sync.Mutex
+	runID  string
+	dir    string
+	file   *os.File
+	staged int
+}
+
+// undoLog is non-nil while the current run is being journaled (--undo).
+var undoLog *undoJournal
+
+func startUndoLog(root string) (*undoJournal, error) {
+	runID := time.Now().UTC().Format("20060102T150405Z") + fmt.Sprintf("-%d", os.Getpid())
+	dir := filepath.Join(root, undoDirName, runID)
+	if err := os.MkdirAll(filepath.Join(dir, "staged"), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "journal.jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Undo log: run %s (revert with: %s undo --target %s %s)\n", runID, os.Args[0], root, runID)
+	return &undoJournal{runID: runID, dir: dir, file: f}, nil
+}
+
+func (j *undoJournal) record(e undoEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// stage moves path into the staging area and journals the deletion.
+func (j *undoJournal) stage(path string) error {
+	j.mu.Lock()
+	j.staged++
+	staged := filepath.Join(j.dir, "staged", fmt.Sprintf("%08d-%s", j.staged, filepath.Base(path)))
+	j.mu.Unlock()
+	if err := os.Rename(path, staged); err != nil {
+		return err
+	}
+	return j.record(undoEntry{Op: "delete", Path: path, Staged: staged})
+}
+
+// removeFile deletes path, or stages it when an undo log is active.
+func removeFile(path string) error {
+	if undoLog != nil {
+		return undoLog.stage(path)
+	}
+	return os.Remove(path)
+}
+
+// removeTree deletes path and, for a directory, everything in it. With an
+// undo log the whole tree is staged in one rename.
+func removeTree(path string) error {
+	if undoLog != nil {
+		return undoLog.stage(path)
+	}
+	return os.RemoveAll(path)
+}
+
+// removeDir deletes the empty directory path and journals it.
+func removeDir(path string) error {
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if undoLog != nil {
+		return undoLog.record(undoEntry{Op: "rmdir", Path: path})
+	}
+	return nil
+}
+
+// makeWayFor moves an existing path about to be overwritten aside: into
+// its --versions, or staged when an undo log is active. Otherwise the
+// rename over it replaces it in one step.
+func makeWayFor(path string) error {
+	if !exists(path) {
+		return nil
+	}
+	if versionsFlag > 0 {
+		return rotateVersions(path)
+	}
+	if undoLog != nil {
+		return undoLog.stage(path)
+	}
+	return nil
+}
+
+// renameFile renames from to to and journals it.
+func renameFile(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	noteTargetEntry(to)
+	if undoLog != nil {
+		return undoLog.record(undoEntry{Op: "rename", From: from, Path: to})
+	}
+	return nil
+}
+
+// recordCreate journals a file the run created.
+func recordCreate(path string) error {
+	noteTargetEntry(path)
+	if undoLog != nil {
+		return undoLog.record(undoEntry{Op: "create", Path: path})
+	}
+	return nil
+}
+
+// makeDir creates a destination directory and journals it.
+func makeDir(path string) error {
+	fileOps.wait()
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return err
+	}
+	noteTargetEntry(path)
+	if err := applyChmod(path, fs.ModeDir|0o755); err != nil {
+		return err
+	}
+	if undoLog != nil {
+		return undoLog.record(undoEntry{Op: "mkdir", Path: path})
+	}
+	return nil
+}
+
+// ensureDir creates path and any missing parents with makeDir, so each
+// one is journaled.
+func ensureDir(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if parent := filepath.Dir(path); parent != path {
+		if err := ensureDir(parent); err != nil {
+			return err
+		}
+	}
+	return makeDir(path)
+}
+
+// runUndo reverts a journaled run, newest change first.
+func runUndo(args []string) {
+	fs := flag.NewFlagSet("undo", flag.ExitOnError)
+	root := fs.String("target", targetFlag, "directory the run was applied to")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s undo --target <directory> [run-id]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseSubcommand(fs, args)
+	if *root == "" || fs.NArg() > 1 {
+		fs.Usage()
+		exit(1)
+	}
+
+	if fs.NArg() == 0 {
+		runs := undoRuns(*root)
+		if len(runs) == 0 {
+			fmt.Println("No undo logs found.")
+			return
+		}
+		fmt.Println("Runs that can be undone:")
+		for _, run := range runs {
+			fmt.Printf("  %s\n", run)
+		}
+		return
+	}
+
+	dir := filepath.Join(*root, undoDirName, fs.Arg(0))
+	entries, err := readUndoJournal(filepath.Join(dir, "journal.jsonl"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+
+	failed := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		if err := revertEntry(entries[i]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d change(s) could not be reverted; undo log kept in %s\n", failed, dir)
+		exit(1)
+	}
+	os.RemoveAll(dir)
+	fmt.Printf("Reverted %d change(s) from run %s.\n", len(entries), fs.Arg(0))
+}
+
+func readUndoJournal(path string) ([]undoEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []undoEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e undoEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// A crash can leave a truncated last line
+			break
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+func revertEntry(e undoEntry) error {
+	switch e.Op {
+	case "create":
+		if err := os.Remove(e.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		fmt.Printf("[REMOVE] %s\n", e.Path)
+	case "mkdir":
+		// Only empty directories are removed; anything else was not ours
+		if err := os.Remove(e.Path); err == nil {
+			fmt.Printf("[RMDIR] %s\n", e.Path)
+		}
+	case "rename":
+		if _, err := os.Lstat(e.From); err == nil {
+			return fmt.Errorf("cannot restore %s: path exists", e.From)
+		}
+		if err := os.MkdirAll(filepath.Dir(e.From), 0o755); err != nil {
+			return err
+		}
+		err := os.Rename(e.Path, e.From)
+		if isCrossDevice(err) {
+			err = moveAcross(e.Path, e.From)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("[RESTORE] %s -> %s\n", e.Path, e.From)
+	case "delete":
+		if _, err := os.Lstat(e.Path); err == nil {
+			return fmt.Errorf("cannot restore %s: path exists", e.Path)
+		}
+		if err := os.MkdirAll(filepath.Dir(e.Path), 0o755); err != nil {
+			return err
+		}
+		if err := os.Rename(e.Staged, e.Path); err != nil {
+			return err
+		}
+		fmt.Printf("[RESTORE] %s\n", e.Path)
+	case "rmdir":
+		if err := os.MkdirAll(e.Path, 0o755); err != nil {
+			return err
+		}
+		fmt.Printf("[MKDIR] %s\n", e.Path)
+	case "append":
+		if err := os.Truncate(e.Path, e.Size); err != nil {
+			return err
+		}
+		fmt.Printf("[TRUNCATE] %s\n", e.Path)
+	default:
+		return fmt.Errorf("unknown undo operation %q", e.Op)
+	}
+	return nil
+}
+
+// undoRuns lists the run IDs with undo logs under root, oldest first.
+func undoRuns(root string) []string {
+	entries, _ := os.ReadDir(filepath.Join(root, undoDirName))
+	var runs []string
+	for _, e := range entries {
+		if e.IsDir() {
+			runs = append(runs, e.Name())
+		}
+	}
+	sort.Strings(runs)
+	return runs
+}
+
+// startUndo begins journaling into root when --undo is set.
+func startUndo(root string) {
+	if !undoFlag {
+		return
+	}
+	j, err := startUndoLog(root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: cannot start undo log: %v\n", err)
+		exit(1)
+	}
+	undoLog = j
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// formatSize renders a byte count with a binary unit suffix.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// parseSize parses sizes such as "512", "16K", "4G" or "1.5MiB" using
+// binary multiples.
+func parseSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(strings.TrimSuffix(str, "B"), "I")
+	mult := int64(1)
+	if n := len(str); n > 0 {
+		if i := strings.IndexByte("KMGTPE", str[n-1]); i >= 0 {
+			mult = int64(1) << (10 * (i + 1))
+			str = str[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(v * float64(mult)), nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runVerify compares two trees without copying anything: every source
+// entry must exist in the target with the same type, size and checksum,
+// and the target must hold nothing more. Differences are listed one per
+// line and make the command exit non-zero.
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	sizeOnly := fs.Bool("size-only", false, "compare sizes only, without reading file contents")
+	hashName := fs.String("hash", hashFlag, "checksum algorithm: xxh3, blake3, sha256 or md5")
+	check := fs.String("check", "", "verify the files listed in this sha256sum/md5sum-style `file` instead (relative to its directory, or the one given)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s verify [--size-only] [--hash xxh3] <source> <target>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "   or: %s verify --check SUMS [--hash sha256] [<directory>]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseSubcommand(fs, args)
+	if *check != "" && fs.NArg() > 1 || *check == "" && fs.NArg() != 2 {
+		fs.Usage()
+		exit(1)
+	}
+	if err := validateHash(*hashName); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	hashFlag = *hashName
+
+	if *check != "" {
+		root := cmp.Or(fs.Arg(0), filepath.Dir(*check))
+		hashGiven := false
+		fs.Visit(func(f *flag.Flag) { hashGiven = hashGiven || f.Name == "hash" })
+		problems, checked, err := checkSumFile(*check, root, !hashGiven)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+		if problems > 0 {
+			fmt.Printf("Check failed: %d of %d files differ\n", problems, checked)
+			exit(1)
+		}
+		fmt.Printf("Check OK: %d files match\n", checked)
+		return
+	}
+
+	srcRoot := filepath.Clean(fs.Arg(0))
+	dstRoot := filepath.Clean(fs.Arg(1))
+	for _, dir := range []string{srcRoot, dstRoot} {
+		if _, err := os.Stat(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+	}
+
+	problems, checked, err := verifyTrees(srcRoot, dstRoot, *sizeOnly)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if problems > 0 {
+		fmt.Printf("Verify failed: %d of %d entries differ\n", problems, checked)
+		exit(1)
+	}
+	fmt.Printf("Verify OK: %d entries match\n", checked)
+}
+
+// verifyTrees walks srcRoot checking each entry against dstRoot, then
+// walks dstRoot for entries the source doesn't have. It returns the
+// number of differences reported and of entries checked.
+func verifyTrees(srcRoot, dstRoot string, sizeOnly bool) (problems, checked int, err error) {
+	report := func(kind, rel, detail string) {
+		problems++
+		line := fmt.Sprintf("[%s] %s", kind, rel)
+		if detail != "" {
+			line += " (" + detail + ")"
+		}
+		logOp(os.Stdout, "%s\n", line)
+	}
+	// The undo journal, part files and excluded entries aren't compared
+	ignored := func(rel string, d fs.DirEntry) bool {
+		if d.IsDir() && d.Name() == undoDirName {
+			return true
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), partSuffix) {
+			return true
+		}
+		return excluded(rel, d.IsDir())
+	}
+
+	filters.useRoot(srcRoot)
+	err = filepath.WalkDir(srcRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(srcRoot, path)
+		if ignored(rel, d) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if rel == "." {
+			return nil
+		}
+		checked++
+		srcInfo, err := d.Info()
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(dstRoot, rel)
+		dstInfo, err := os.Lstat(dstPath)
+		if os.IsNotExist(err) {
+			report("MISSING", rel, "")
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if srcInfo.Mode().Type() != dstInfo.Mode().Type() {
+			report("TYPE", rel, fmt.Sprintf("%s in source, %s in target", srcInfo.Mode().Type(), dstInfo.Mode().Type()))
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !srcInfo.Mode().IsRegular() {
+			return nil
+		}
+		if srcInfo.Size() != dstInfo.Size() {
+			report("SIZE", rel, fmt.Sprintf("%s in source, %s in target", formatSize(srcInfo.Size()), formatSize(dstInfo.Size())))
+			return nil
+		}
+		if sizeOnly {
+			return nil
+		}
+		want, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		got, err := hashFile(dstPath)
+		if err != nil {
+			return err
+		}
+		if got != want {
+			report("CHECKSUM", rel, fmt.Sprintf("%s %s in source, %s in target", hashFlag, want, got))
+		}
+		return nil
+	})
+	if err != nil {
+		return problems, checked, err
+	}
+
+	// Entries only the target has
+	err = filepath.WalkDir(dstRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dstRoot, path)
+		if ignored(rel, d) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if rel == "." {
+			return nil
+		}
+		if _, err := os.Lstat(filepath.Join(srcRoot, rel)); os.IsNotExist(err) {
+			report("EXTRA", rel, "")
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		} else if err != nil {
+			return err
+		}
+		return nil
+	})
+	return problems, checked, err
+}
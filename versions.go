@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// versionsFlag is how many replaced copies of a changed target file are
+// kept as file.~1~ (newest) to file.~N~ (--versions). It also makes runs
+// update changed files rather than skip them.
+var versionsFlag int
+
+// versionSuffix matches the names rotateVersions gives old copies.
+var versionSuffix = regexp.MustCompile(`\.~\d+~$`)
+
+func versionName(path string, n int) string {
+	return fmt.Sprintf("%s.~%d~", path, n)
+}
+
+// rotateVersions moves path out of the way of its replacement as version
+// 1, shifting older versions up and dropping the one beyond --versions.
+// Every step is journaled, so undo puts the versions back too.
+func rotateVersions(path string) error {
+	if oldest := versionName(path, versionsFlag); exists(oldest) {
+		if err := removeFile(oldest); err != nil {
+			return err
+		}
+	}
+	for n := versionsFlag - 1; n >= 1; n-- {
+		if from := versionName(path, n); exists(from) {
+			if err := renameFile(from, versionName(path, n+1)); err != nil {
+				return err
+			}
+		}
+	}
+	return renameFile(path, versionName(path, 1))
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
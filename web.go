@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+//go:embed web/index.html
+var dashboardHTML []byte
+
+// dashboardToken is the secret the dashboard's API wants, generated when it
+// starts and printed as part of its URL. The dashboard can cancel the run
+// and shows every path it touches, so knowing its address isn't enough.
+var dashboardToken string
+
+// newDashboardMux serves the embedded dashboard, a JSON status endpoint, a
+// WebSocket feed of the same status and a cancel endpoint that aborts the
+// run. Everything but the page itself needs dashboardToken.
+func newDashboardMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardHTML)
+	})
+	mux.HandleFunc("GET /api/status", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status.snapshot())
+	})
+	mux.HandleFunc("GET /api/events", serveEvents)
+	mux.HandleFunc("POST /api/cancel", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		if !sameOrigin(r) {
+			http.Error(w, "cross-origin request refused", http.StatusForbidden)
+			return
+		}
+		control.abort()
+		w.WriteHeader(http.StatusAccepted)
+	})
+	return mux
+}
+
+// authorized reports whether r carries dashboardToken, as a "token" query
+// parameter or a bearer token, and refuses the request if not.
+func authorized(w http.ResponseWriter, r *http.Request) bool {
+	token := r.URL.Query().Get("token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(dashboardToken)) != 1 {
+		http.Error(w, "missing or wrong dashboard token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// sameOrigin reports whether r comes from the dashboard's own pages or
+// from a client that isn't a browser, which sends no Origin. Browsers
+// always send it with cross-origin POSTs and WebSocket upgrades, so other
+// sites can't cancel the run or read its events.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// startDashboard listens on addr, or on the systemd-activated socket named
+// "web", and serves the dashboard in the background. The URL it prints
+// carries the token the API wants.
+func startDashboard(addr string) error {
+	ln := activatedListener("web")
+	if ln == nil {
+		var err error
+		if ln, err = net.Listen("tcp", addr); err != nil {
+			return err
+		}
+	}
+	dashboardToken = rand.Text()
+	fmt.Fprintf(os.Stderr, "Dashboard listening on http://%s/?token=%s\n", ln.Addr(), dashboardToken)
+	go http.Serve(ln, newDashboardMux())
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var webhookClient = &http.Client{Timeout: netTimeout, Transport: webhookTransport()}
+
+// webhookTransport is the default transport, with proxies chosen by
+// httpProxy.
+func webhookTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = httpProxy
+	return t
+}
+
+// webhookEvent is the JSON body POSTed to --webhook.
+type webhookEvent struct {
+	Event   string          `json:"event"`
+	Summary *statusSnapshot `json:"summary,omitempty"`
+	Failure *failure        `json:"failure,omitempty"`
+}
+
+// postWebhook sends event to url and treats any non-2xx reply as an error.
+func postWebhook(url string, event webhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// errorEvents queues the --webhook-errors events for a goroutine that
+// posts them one by one, so a slow webhook doesn't hold up the transfers.
+// When the queue is full events are dropped and counted; the summary
+// event still lists every failure.
+var (
+	errorEvents     chan webhookEvent
+	errorEventsOnce sync.Once
+	errorEventsSent sync.WaitGroup
+	droppedEvents   int64
+)
+
+// queueWebhookError queues an "error" event for f without waiting.
+func queueWebhookError(f failure) {
+	errorEventsOnce.Do(func() {
+		errorEvents = make(chan webhookEvent, 64)
+		go func() {
+			for event := range errorEvents {
+				if err := postWebhook(webhookFlag, event); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: webhook failed: %v\n", err)
+				}
+				errorEventsSent.Done()
+			}
+		}()
+	})
+	errorEventsSent.Add(1)
+	select {
+	case errorEvents <- webhookEvent{Event: "error", Failure: &f}:
+	default:
+		errorEventsSent.Done()
+		atomic.AddInt64(&droppedEvents, 1)
+	}
+}
+
+// flushWebhookErrors gives the queued error events up to netTimeout to
+// go out, so they arrive before the summary, and reports any dropped.
+func flushWebhookErrors() {
+	done := make(chan struct{})
+	go func() {
+		errorEventsSent.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(netTimeout):
+		fmt.Fprintf(os.Stderr, "Warning: webhook too slow, not all error events were sent\n")
+	}
+	if n := atomic.LoadInt64(&droppedEvents); n > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d webhook error events dropped, the webhook fell behind\n", n)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// wsInterval is how often the event feed checks for new progress.
+const wsInterval = 500 * time.Millisecond
+
+// WebSocket opcodes (RFC 6455)
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+// serveEvents upgrades the request to a WebSocket and streams a status
+// snapshot, the same JSON as /api/status, whenever it changes. The feed
+// ends when the client closes it. Browsers don't apply the same-origin
+// policy to WebSockets, so an upgrade from another site's page is refused.
+func serveEvents(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin WebSocket refused", http.StatusForbidden)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "WebSocket upgrade required", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "cannot upgrade connection", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	ws := &wsConn{conn: conn}
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		ws.readLoop(rw.Reader)
+	}()
+
+	ticker := time.NewTicker(wsInterval)
+	defer ticker.Stop()
+	var last []byte
+	for {
+		msg, err := json.Marshal(status.snapshot())
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(msg, last) {
+			if err := ws.writeFrame(wsText, msg); err != nil {
+				return
+			}
+			last = msg
+		}
+		select {
+		case <-ticker.C:
+		case <-closed:
+			return
+		}
+	}
+}
+
+// headerHasToken reports whether the comma-separated header name
+// contains token, ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn is the server side of a WebSocket; writes come from both the
+// feed and the reader answering pings.
+type wsConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// writeFrame sends a single unmasked, final frame.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = binary.BigEndian.AppendUint16(append(header, 126), uint16(n))
+	default:
+		header = binary.BigEndian.AppendUint64(append(header, 127), uint64(n))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(netTimeout))
+	_, err := c.conn.Write(append(header, payload...))
+	return err
+}
+
+// readLoop reads client frames until the connection closes, answering
+// pings and close frames and discarding everything else.
+func (c *wsConn) readLoop(r *bufio.Reader) {
+	for {
+		opcode, payload, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsPing:
+			c.writeFrame(wsPong, payload)
+		case wsClose:
+			c.writeFrame(wsClose, payload)
+			return
+		}
+	}
+}
+
+// wsMaxFrame bounds the frames a client may send; the feed only expects
+// control frames.
+const wsMaxFrame = 1 << 16
+
+// readFrame reads one masked client frame.
+func readFrame(r *bufio.Reader) (opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	if head[1]&0x80 == 0 {
+		return 0, nil, errors.New("unmasked client frame")
+	}
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxFrame {
+		return 0, nil, errors.New("client frame too large")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return head[0] & 0x0F, payload, nil
+}
@@ -0,0 +1,20 @@
+package main
+
+// Windows file attributes copied by --win-attrs.
+const (
+	winReadonly = 0x1
+	winHidden   = 0x2
+	winSystem   = 0x4
+	winArchive  = 0x20
+
+	winAttrsCopied = winReadonly | winHidden | winSystem | winArchive
+)
+
+var (
+	// winAttrsFlag copies the hidden, system, archive and read-only
+	// attributes from source entries to their copies on Windows.
+	winAttrsFlag bool
+	// clearArchiveFlag clears the archive attribute of each source file
+	// once it has been copied, as backup programs traditionally do.
+	clearArchiveFlag bool
+)
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+const winAttrsSupported = false
+
+func copyWinAttrs(src, dst string) {}
+
+func clearReadonly(path string) {}
+
+func clearArchive(path string) {}
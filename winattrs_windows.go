@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const winAttrsSupported = true
+
+func getWinAttrs(path string) (uint32, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	return syscall.GetFileAttributes(p)
+}
+
+func setWinAttrs(path string, attrs uint32) error {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	return syscall.SetFileAttributes(p, attrs)
+}
+
+// copyWinAttrs gives dst the --win-attrs attributes of src, leaving its
+// other attributes alone. It runs last, as read-only would block the rest.
+func copyWinAttrs(src, dst string) {
+	if !winAttrsFlag {
+		return
+	}
+	srcAttrs, err := getWinAttrs(src)
+	if err != nil {
+		return
+	}
+	dstAttrs, err := getWinAttrs(dst)
+	if err == nil {
+		err = setWinAttrs(dst, dstAttrs&^winAttrsCopied|srcAttrs&winAttrsCopied)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot set attributes on %s: %v\n", dst, err)
+	}
+}
+
+// clearReadonly lets a copy with --win-attrs replace a target file it
+// made read-only on an earlier run.
+func clearReadonly(path string) {
+	if !winAttrsFlag {
+		return
+	}
+	if attrs, err := getWinAttrs(path); err == nil && attrs&winReadonly != 0 {
+		setWinAttrs(path, attrs&^winReadonly)
+	}
+}
+
+// clearArchive clears the archive attribute of a source file that has
+// been copied (--clear-archive).
+func clearArchive(path string) {
+	if !clearArchiveFlag {
+		return
+	}
+	attrs, err := getWinAttrs(path)
+	if err == nil && attrs&winArchive != 0 {
+		err = setWinAttrs(path, attrs&^winArchive)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot clear the archive attribute of %s: %v\n", path, err)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// xxh3 is a streaming XXH3-64 (seed 0, default secret), the fast
+// non-cryptographic hash used by default for change detection. It is
+// written out here to keep the module free of dependencies.
+type xxh3 struct {
+	acc     [8]uint64
+	stripes int    // stripes accumulated in the current block
+	buf     []byte // input not yet accumulated, after the last processed stripe
+	pos     int    // start of the unprocessed part of buf
+	total   uint64
+}
+
+const (
+	xxPrime32_1 = 0x9E3779B1
+	xxPrime32_2 = 0x85EBCA77
+	xxPrime32_3 = 0xC2B2AE3D
+	xxPrime64_1 = 0x9E3779B185EBCA87
+	xxPrime64_2 = 0xC2B2AE3D27D4EB4F
+	xxPrime64_3 = 0x165667B19E3779F9
+	xxPrime64_4 = 0x85EBCA77C2B2AE63
+	xxPrime64_5 = 0x27D4EB2F165667C5
+	xxPrimeMx1  = 0x165667919E3779F9
+	xxPrimeMx2  = 0x9FB21C651E98DF25
+
+	xxStripeLen       = 64
+	xxStripesPerBlock = (len(xxSecret) - xxStripeLen) / 8
+	xxMidSizeMax      = 240
+	xxFlushSize       = 4096
+)
+
+var xxSecret = [192]byte{
+	0xb8, 0xfe, 0x6c, 0x39, 0x23, 0xa4, 0x4b, 0xbe, 0x7c, 0x01, 0x81, 0x2c, 0xf7, 0x21, 0xad, 0x1c,
+	0xde, 0xd4, 0x6d, 0xe9, 0x83, 0x90, 0x97, 0xdb, 0x72, 0x40, 0xa4, 0xa4, 0xb7, 0xb3, 0x67, 0x1f,
+	0xcb, 0x79, 0xe6, 0x4e, 0xcc, 0xc0, 0xe5, 0x78, 0x82, 0x5a, 0xd0, 0x7d, 0xcc, 0xff, 0x72, 0x21,
+	0xb8, 0x08, 0x46, 0x74, 0xf7, 0x43, 0x24, 0x8e, 0xe0, 0x35, 0x90, 0xe6, 0x81, 0x3a, 0x26, 0x4c,
+	0x3c, 0x28, 0x52, 0xbb, 0x91, 0xc3, 0x00, 0xcb, 0x88, 0xd0, 0x65, 0x8b, 0x1b, 0x53, 0x2e, 0xa3,
+	0x71, 0x64, 0x48, 0x97, 0xa2, 0x0d, 0xf9, 0x4e, 0x38, 0x19, 0xef, 0x46, 0xa9, 0xde, 0xac, 0xd8,
+	0xa8, 0xfa, 0x76, 0x3f, 0xe3, 0x9c, 0x34, 0x3f, 0xf9, 0xdc, 0xbb, 0xc7, 0xc7, 0x0b, 0x4f, 0x1d,
+	0x8a, 0x51, 0xe0, 0x4b, 0xcd, 0xb4, 0x59, 0x31, 0xc8, 0x9f, 0x7e, 0xc9, 0xd9, 0x78, 0x73, 0x64,
+	0xea, 0xc5, 0xac, 0x83, 0x34, 0xd3, 0xeb, 0xc3, 0xc5, 0x81, 0xa0, 0xff, 0xfa, 0x13, 0x63, 0xeb,
+	0x17, 0x0d, 0xdd, 0x51, 0xb7, 0xf0, 0xda, 0x49, 0xd3, 0x16, 0x55, 0x26, 0x29, 0xd4, 0x68, 0x9e,
+	0x2b, 0x16, 0xbe, 0x58, 0x7d, 0x47, 0xa1, 0xfc, 0x8f, 0xf8, 0xb8, 0xd1, 0x7a, 0xd0, 0x31, 0xce,
+	0x45, 0xcb, 0x3a, 0x8f, 0x95, 0x16, 0x04, 0x28, 0xaf, 0xd7, 0xfb, 0xca, 0xbb, 0x4b, 0x40, 0x7e,
+}
+
+func newXXH3() hash.Hash64 {
+	h := &xxh3{}
+	h.Reset()
+	return h
+}
+
+func (h *xxh3) Reset() {
+	h.acc = [8]uint64{xxPrime32_3, xxPrime64_1, xxPrime64_2, xxPrime64_3, xxPrime64_4, xxPrime32_2, xxPrime64_5, xxPrime32_1}
+	h.stripes, h.buf, h.pos, h.total = 0, h.buf[:0], 0, 0
+}
+
+func (h *xxh3) Size() int      { return 8 }
+func (h *xxh3) BlockSize() int { return xxStripeLen }
+
+func (h *xxh3) Write(p []byte) (int, error) {
+	h.buf = append(h.buf, p...)
+	h.total += uint64(len(p))
+	if len(h.buf) >= xxFlushSize {
+		h.pos = xxAccumulate(&h.acc, &h.stripes, h.buf, h.pos)
+		// Keep the last processed stripe: the final one may overlap it
+		keep := h.pos - xxStripeLen
+		h.buf = append(h.buf[:0], h.buf[keep:]...)
+		h.pos -= keep
+	}
+	return len(p), nil
+}
+
+func (h *xxh3) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, h.Sum64())
+}
+
+func (h *xxh3) Sum64() uint64 {
+	if h.total <= xxMidSizeMax {
+		return xxh3Short(h.buf)
+	}
+	acc, stripes := h.acc, h.stripes
+	xxAccumulate(&acc, &stripes, h.buf, h.pos)
+	xxAccumulate512(&acc, h.buf[len(h.buf)-xxStripeLen:], xxSecret[len(xxSecret)-xxStripeLen-7:])
+	result := h.total * xxPrime64_1
+	for i := 0; i < 4; i++ {
+		result += xxMul128Fold64(acc[2*i]^xxRead64(xxSecret[11+16*i:]), acc[2*i+1]^xxRead64(xxSecret[11+16*i+8:]))
+	}
+	return xxAvalanche(result)
+}
+
+// xxAccumulate consumes every whole stripe of data from pos that still
+// has input after it, scrambling after each block, and returns the new
+// position. The last stripe is left for Sum64.
+func xxAccumulate(acc *[8]uint64, stripes *int, data []byte, pos int) int {
+	for pos+xxStripeLen < len(data) {
+		xxAccumulate512(acc, data[pos:], xxSecret[*stripes*8:])
+		pos += xxStripeLen
+		if *stripes++; *stripes == xxStripesPerBlock {
+			secret := xxSecret[len(xxSecret)-xxStripeLen:]
+			for i := range acc {
+				a := acc[i]
+				a ^= a >> 47
+				a ^= xxRead64(secret[8*i:])
+				acc[i] = a * xxPrime32_1
+			}
+			*stripes = 0
+		}
+	}
+	return pos
+}
+
+func xxAccumulate512(acc *[8]uint64, in, secret []byte) {
+	for i := range acc {
+		v := xxRead64(in[8*i:])
+		k := v ^ xxRead64(secret[8*i:])
+		acc[i^1] += v
+		acc[i] += uint64(uint32(k)) * (k >> 32)
+	}
+}
+
+// xxh3Short hashes inputs of up to 240 bytes in one go.
+func xxh3Short(in []byte) uint64 {
+	n := uint64(len(in))
+	s := xxSecret[:]
+	switch {
+	case n == 0:
+		return xx64Avalanche(xxRead64(s[56:]) ^ xxRead64(s[64:]))
+	case n <= 3:
+		combined := uint32(in[0])<<16 | uint32(in[n>>1])<<24 | uint32(in[n-1]) | uint32(n)<<8
+		flip := uint64(xxRead32(s) ^ xxRead32(s[4:]))
+		return xx64Avalanche(uint64(combined) ^ flip)
+	case n <= 8:
+		flip := xxRead64(s[8:]) ^ xxRead64(s[16:])
+		v := uint64(xxRead32(in[n-4:])) + uint64(xxRead32(in))<<32
+		return xxRrmxmx(v^flip, n)
+	case n <= 16:
+		lo := xxRead64(in) ^ (xxRead64(s[24:]) ^ xxRead64(s[32:]))
+		hi := xxRead64(in[n-8:]) ^ (xxRead64(s[40:]) ^ xxRead64(s[48:]))
+		return xxAvalanche(n + bits.ReverseBytes64(lo) + hi + xxMul128Fold64(lo, hi))
+	case n <= 128:
+		acc := n * xxPrime64_1
+		if n > 32 {
+			if n > 64 {
+				if n > 96 {
+					acc += xxMix16(in[48:], s[96:]) + xxMix16(in[n-64:], s[112:])
+				}
+				acc += xxMix16(in[32:], s[64:]) + xxMix16(in[n-48:], s[80:])
+			}
+			acc += xxMix16(in[16:], s[32:]) + xxMix16(in[n-32:], s[48:])
+		}
+		acc += xxMix16(in, s) + xxMix16(in[n-16:], s[16:])
+		return xxAvalanche(acc)
+	}
+	acc := n * xxPrime64_1
+	for i := 0; i < 8; i++ {
+		acc += xxMix16(in[16*i:], s[16*i:])
+	}
+	acc = xxAvalanche(acc)
+	for i := 8; i < int(n/16); i++ {
+		acc += xxMix16(in[16*i:], s[16*(i-8)+3:])
+	}
+	acc += xxMix16(in[n-16:], s[136-17:])
+	return xxAvalanche(acc)
+}
+
+func xxMix16(in, secret []byte) uint64 {
+	return xxMul128Fold64(xxRead64(in)^xxRead64(secret), xxRead64(in[8:])^xxRead64(secret[8:]))
+}
+
+func xxMul128Fold64(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return hi ^ lo
+}
+
+func xxAvalanche(h uint64) uint64 {
+	h ^= h >> 37
+	h *= xxPrimeMx1
+	return h ^ h>>32
+}
+
+func xx64Avalanche(h uint64) uint64 {
+	h ^= h >> 33
+	h *= xxPrime64_2
+	h ^= h >> 29
+	h *= xxPrime64_3
+	return h ^ h>>32
+}
+
+func xxRrmxmx(h, n uint64) uint64 {
+	h ^= bits.RotateLeft64(h, 49) ^ bits.RotateLeft64(h, 24)
+	h *= xxPrimeMx2
+	h ^= h>>35 + n
+	h *= xxPrimeMx2
+	return h ^ h>>28
+}
+
+func xxRead64(b []byte) uint64 { return binary.LittleEndian.Uint64(b) }
+func xxRead32(b []byte) uint32 { return binary.LittleEndian.Uint32(b) }